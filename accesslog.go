@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Formats of the access log.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// accessEntry is a line of the JSON access log.
+type accessEntry struct {
+	Time       string  `json:"time"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RemoteIP   string  `json:"remote_ip"`
+	UserAgent  string  `json:"user_agent"`
+	Message    string  `json:"message,omitempty"`
+}
+
+// elapsed gives how long a request has taken since ServeHTTP started it.
+func elapsed(ctx *fasthttp.RequestCtx) time.Duration {
+	start, ok := ctx.UserValue("start").(time.Time)
+	if !ok {
+		start = ctx.Time()
+	}
+	return time.Since(start)
+}
+
+// logRequest logs how a request was answered and how long it took. With
+// the JSON format the message is kept for the request's entry, written
+// once it's done.
+func logRequest(ctx *fasthttp.RequestCtx, code int, msg string) {
+	if entry, ok := ctx.UserValue("accesslog").(*accessEntry); ok {
+		entry.Message = msg
+		return
+	}
+	log.Output(2, fmt.Sprintf(logf, ctx.Method(), ctx.Path(), code, msg, elapsed(ctx).Round(time.Microsecond)))
+}
+
+// responseSize gives the size of the response body, or zero if it's
+// streamed without a known length.
+func responseSize(ctx *fasthttp.RequestCtx) int {
+	if ctx.Response.IsBodyStream() {
+		// reading the size of a stream would consume it
+		if n := ctx.Response.Header.ContentLength(); n > 0 {
+			return n
+		}
+		return 0
+	}
+	return len(ctx.Response.Body())
+}
+
+// jsonAccessLog wraps a handler to write a JSON access log entry for each
+// request.
+func jsonAccessLog(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	logger := log.New(log.Writer(), "", 0)
+	return func(ctx *fasthttp.RequestCtx) {
+		entry := new(accessEntry)
+		ctx.SetUserValue("accesslog", entry)
+		h(ctx)
+		entry.Time = ctx.Time().UTC().Format(time.RFC3339)
+		entry.Method = string(ctx.Method())
+		entry.Path = string(ctx.Path())
+		entry.Status = ctx.Response.StatusCode()
+		entry.Bytes = responseSize(ctx)
+		entry.DurationMS = float64(elapsed(ctx).Microseconds()) / 1000
+		entry.RemoteIP = clientIP(ctx).String()
+		entry.UserAgent = string(ctx.UserAgent())
+		b, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("couldn't log request as JSON: %v", err)
+			return
+		}
+		logger.Print(string(b))
+	}
+}
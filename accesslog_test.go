@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureLog gives what the standard logger writes while f runs.
+func captureLog(f func()) string {
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(ioutil.Discard)
+	f()
+	return buf.String()
+}
+
+func TestTextAccessLog(t *testing.T) {
+	s := newTestServer(t, "", map[string]string{"page.md": "page"})
+	out := captureLog(func() { get(s, "/page") })
+	line := regexp.MustCompile(`\[GET /page\] 200: markdown \S+page\.md \(\d+(\.\d+)?[µm]?s\)`)
+	if !line.MatchString(out) {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestJSONAccessLog(t *testing.T) {
+	s := newTestServer(t, "log_format: json\n", map[string]string{"page.md": "page"})
+	tests := []struct {
+		path    string
+		status  int
+		message string
+	}{
+		{"/page", 200, "markdown "},
+		{"/missing", 404, ""},
+	}
+	for _, tt := range tests {
+		out := captureLog(func() { get(s, tt.path, "User-Agent", "tester") })
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 1 {
+			t.Errorf("GET %s: logged %d lines: %q", tt.path, len(lines), out)
+			continue
+		}
+		var entry accessEntry
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+			t.Errorf("GET %s: logged %q: %v", tt.path, lines[0], err)
+			continue
+		}
+		if entry.Method != "GET" || entry.Path != tt.path || entry.Status != tt.status ||
+			entry.RemoteIP != "192.0.2.1" || entry.UserAgent != "tester" || entry.Time == "" ||
+			entry.DurationMS < 0 || !strings.HasPrefix(entry.Message, tt.message) {
+			t.Errorf("GET %s: logged %+v", tt.path, entry)
+		}
+		if tt.status == 200 && entry.Bytes == 0 {
+			t.Errorf("GET %s: logged no bytes", tt.path)
+		}
+	}
+}
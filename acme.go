@@ -0,0 +1,51 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeChallengePrefix is the path of ACME HTTP-01 challenges.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// newACMEManager creates the manager that obtains and renews certificates
+// for hosts from Let's Encrypt, keeping them in cacheDir.
+func newACMEManager(email, cacheDir string, hosts []string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      email,
+	}
+}
+
+// serveACMEChallenge answers ACME HTTP-01 challenges, reporting whether the
+// request was one. Challenges must be answered over plain HTTP, ahead of
+// TLS redirects.
+func (s *server) serveACMEChallenge(ctx *fasthttp.RequestCtx) bool {
+	if s.tls.acme == nil || !strings.HasPrefix(string(ctx.Path()), acmeChallengePrefix) {
+		return false
+	}
+	fasthttpadaptor.NewFastHTTPHandler(s.tls.acme.HTTPHandler(nil))(ctx)
+	return true
+}
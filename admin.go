@@ -0,0 +1,82 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serveAdmin answers requests under the admin path, reporting whether the
+// request was one. Admin requests carry the admin secret as a bearer
+// token, and are never resolved to files.
+func (s *server) serveAdmin(ctx *fasthttp.RequestCtx) bool {
+	if s.admin.path == "" {
+		return false
+	}
+	pathStr := string(ctx.Path())
+	if pathStr != s.admin.path && !strings.HasPrefix(pathStr, s.admin.path+"/") {
+		return false
+	}
+	token := strings.TrimPrefix(string(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.admin.secret)) != 1 {
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="`+s.host+`-admin"`)
+		ctx.Error("Unauthorized", fasthttp.StatusUnauthorized)
+		logRequest(ctx, fasthttp.StatusUnauthorized, "admin")
+		return true
+	}
+	switch strings.TrimPrefix(pathStr, s.admin.path) {
+	case "/flush":
+		s.adminFlush(ctx)
+	default:
+		handlerNotFound()(ctx)
+	}
+	return true
+}
+
+// adminFlush empties the cache, or with a path query argument evicts the
+// responses for that path on every virtual host, as when its file changes.
+func (s *server) adminFlush(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		ctx.Response.Header.Set("Allow", "POST")
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		logRequest(ctx, fasthttp.StatusMethodNotAllowed, "admin flush")
+		return
+	}
+	if s.cache == nil {
+		handlerNotFound()(ctx)
+		return
+	}
+	target := string(ctx.QueryArgs().Peek("path"))
+	if target == "" {
+		s.flushCache("admin request")
+		ctx.Response.Header.SetContentType("text/plain; charset=utf-8")
+		ctx.SetBodyString("flushed\n")
+	} else {
+		evicted := s.evictPath(target, false)
+		for _, vs := range s.vhosts {
+			evicted += vs.evictPath(target, false)
+		}
+		ctx.Response.Header.SetContentType("text/plain; charset=utf-8")
+		ctx.SetBodyString(fmt.Sprintf("evicted %d\n", evicted))
+	}
+	logRequest(ctx, fasthttp.StatusOK, "admin flush "+target)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAdminFlushPath(t *testing.T) {
+	yml := vhostSettingsYAML + "admin:\n  path: /__admin\n  secret: token\n"
+	files := map[string]string{
+		"page.md":    "default",
+		"other.md":   "other",
+		"a/page.md":  "site A",
+		"a/other.md": "other A",
+		"b/page.md":  "site B",
+	}
+	tests := []struct {
+		name   string
+		target string
+		kept   []string
+	}{
+		{"everything", "", nil},
+		{"path on every host", "/page", []string{"/other", "a.example.com/other"}},
+		{"other path", "/other", []string{"/page", "a.example.com/page", "b.example.com/page"}},
+		{"nothing there", "/missing", []string{"/other", "/page", "a.example.com/other", "a.example.com/page", "b.example.com/page"}},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, yml, files)
+		for _, uri := range []string{"/page", "/other", "http://a.example.com/page", "http://a.example.com/other", "http://b.example.com/page"} {
+			get(s, uri)
+		}
+		req := new(fasthttp.Request)
+		req.Header.SetMethod("POST")
+		req.Header.Set("Authorization", "Bearer token")
+		req.SetRequestURI("/__admin/flush")
+		if tt.target != "" {
+			req.URI().QueryArgs().Set("path", tt.target)
+		}
+		if resp := serveRequest(s, req); resp.StatusCode() != 200 {
+			t.Fatalf("%s: got %d %q", tt.name, resp.StatusCode(), resp.Body())
+		}
+		var kept []string
+		for key := range s.cache.Items() {
+			kept = append(kept, key)
+		}
+		sort.Strings(kept)
+		if len(kept) != len(tt.kept) || len(kept) > 0 && !reflect.DeepEqual(kept, tt.kept) {
+			t.Errorf("%s: kept %v, want %v", tt.name, kept, tt.kept)
+		}
+	}
+}
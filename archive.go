@@ -0,0 +1,133 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// wantsZip reports whether a request asks for a directory as a zip archive.
+func (s *server) wantsZip(ctx *fasthttp.RequestCtx) bool {
+	return s.zip.enabled && string(ctx.QueryArgs().Peek("download")) == "zip"
+}
+
+// serveZip streams the regular files below a directory as a zip archive.
+// Hidden files and directories are left out, as is anything that isn't
+// served: directory templates, gone paths, and secured routes the request
+// isn't authenticated for. The archive is refused if it would exceed the configured file count
+// or size.
+func (s *server) serveZip(ctx *fasthttp.RequestCtx, dir string) {
+	base := strings.TrimSuffix(string(ctx.Path()), "/")
+	var files []string
+	var total int64
+	err := fp.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := fp.Rel(dir, p)
+		if p != dir && (strings.HasPrefix(info.Name(), ".") || !info.IsDir() && isDirTemplate(info.Name()) || !s.zipIncludes(ctx, base+"/"+fp.ToSlash(rel), info.IsDir())) {
+			if info.IsDir() {
+				return fp.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		files = append(files, p)
+		total += info.Size()
+		if s.zip.maxFiles > 0 && len(files) > s.zip.maxFiles {
+			return fmt.Errorf("more than %d files", s.zip.maxFiles)
+		}
+		if s.zip.maxBytes > 0 && total > s.zip.maxBytes {
+			return fmt.Errorf("more than %d bytes", s.zip.maxBytes)
+		}
+		return nil
+	})
+	if err != nil {
+		ctx.Response.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.Response.SetBodyString("Directory too large to download")
+		logRequest(ctx, fasthttp.StatusForbidden, "zip "+dir+": "+err.Error())
+		return
+	}
+
+	name := fp.Base(dir)
+	if name == string(fp.Separator) || name == "." {
+		name = "download"
+	}
+	ctx.Response.Header.Set("Content-Type", "application/zip")
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		zw := zip.NewWriter(w)
+		for _, p := range files {
+			if err := addToZip(zw, dir, p); err != nil {
+				log.Printf("couldn't add %s to zip: %v", p, err)
+				break
+			}
+		}
+		if err := zw.Close(); err != nil {
+			log.Printf("couldn't finish zip of %s: %v", dir, err)
+		}
+	})
+	logRequest(ctx, fasthttp.StatusOK, fmt.Sprintf("zip %s (%d files)", dir, len(files)))
+}
+
+// zipIncludes reports whether a file or directory, at a request path below
+// the directory being archived, would be served to the request. Files that
+// are rendered are also checked without their extension.
+func (s *server) zipIncludes(ctx *fasthttp.RequestCtx, urlPath string, isDir bool) bool {
+	if isDir && s.isGone(urlPath+"/") || s.isGone(urlPath) {
+		return false
+	}
+	if ext := path.Ext(urlPath); !isDir && s.renderable[strings.TrimPrefix(ext, ".")] && s.isGone(strings.TrimSuffix(urlPath, ext)) {
+		return false
+	}
+	route := routeOf(urlPath)
+	if _, isSecret := s.secrets()[route]; isSecret {
+		ok, _ := s.checkAuth(ctx, route)
+		return ok
+	}
+	return true
+}
+
+func addToZip(zw *zip.Writer, dir, p string) error {
+	rel, err := fp.Rel(dir, p)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := zw.Create(fp.ToSlash(rel))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// zipNames gives the names of the files in a zip archive.
+func zipNames(t *testing.T, body []byte) []string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestZipLeavesOutUnserved(t *testing.T) {
+	s := newTestServer(t, "download:\n  zip: true\nsecrets:\n  private: pw\ngone: [/old/, /docs/removed]\n", map[string]string{
+		"top.md":              "top",
+		"docs/page.md":        "page",
+		"docs/removed.md":     "removed",
+		"docs/.hidden":        "hidden",
+		"docs/layout.html":    "{{ .Content }}",
+		"private/secret.md":   "secret",
+		"private/nested/a.md": "a",
+		"old/stale.md":        "stale",
+	})
+	tests := []struct {
+		name     string
+		uri      string
+		password string
+		want     []string
+	}{
+		{"root unauthenticated", "/?download=zip", "", []string{"docs/page.md", "top.md"}},
+		{"root authenticated", "/?download=zip", "pw", []string{"docs/page.md", "private/nested/a.md", "private/secret.md", "top.md"}},
+		{"root wrong password", "/?download=zip", "wrong", []string{"docs/page.md", "top.md"}},
+		{"subdirectory", "/docs/?download=zip", "", []string{"page.md"}},
+		{"secured route", "/private/?download=zip", "pw", []string{"nested/a.md", "secret.md"}},
+	}
+	for _, tt := range tests {
+		var headers []string
+		if tt.password != "" {
+			// the credentials of the secured route, even when sent for a
+			// path outside it
+			challenge := string(get(s, "/private/").Header.Peek("WWW-Authenticate"))
+			path := strings.TrimSuffix(tt.uri, "?download=zip")
+			headers = []string{"Authorization", digestAuthorization(challenge, "GET", path, "any", tt.password, "auth", nil)}
+		}
+		resp := get(s, tt.uri, headers...)
+		if resp.StatusCode() != 200 {
+			t.Errorf("%s: got %d", tt.name, resp.StatusCode())
+			continue
+		}
+		if got := zipNames(t, resp.Body()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: archived %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if resp := get(s, "/private/?download=zip"); resp.StatusCode() != 401 {
+		t.Errorf("unauthenticated archive of a secured route: got %d, want 401", resp.StatusCode())
+	}
+}
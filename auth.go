@@ -0,0 +1,350 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// routeSecret holds the credentials for a secured route: either a single
+// password for any username, or passwords by username.
+type routeSecret struct {
+	password string
+	users    map[string]string
+}
+
+// UnmarshalYAML accepts either a password or a map of usernames to
+// passwords.
+func (rs *routeSecret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&rs.password); err == nil {
+		return nil
+	}
+	return unmarshal(&rs.users)
+}
+
+// MarshalYAML redacts the passwords.
+func (rs routeSecret) MarshalYAML() (interface{}, error) {
+	if rs.users == nil {
+		return "REDACTED", nil
+	}
+	users := make(map[string]string)
+	for username := range rs.users {
+		users[username] = "REDACTED"
+	}
+	return users, nil
+}
+
+// passwordFor gives the password for a username, if the user may access
+// the route.
+func (rs routeSecret) passwordFor(username string) (string, bool) {
+	if rs.users == nil {
+		return rs.password, true
+	}
+	password, ok := rs.users[username]
+	return password, ok
+}
+
+// passwords gives every password for the route.
+func (rs routeSecret) passwords() []string {
+	if rs.users == nil {
+		return []string{rs.password}
+	}
+	var all []string
+	for _, password := range rs.users {
+		all = append(all, password)
+	}
+	return all
+}
+
+// secrets gives the current credentials of secured routes. The map must
+// not be modified.
+func (s *server) secrets() map[string]routeSecret {
+	s.secretMu.RLock()
+	defer s.secretMu.RUnlock()
+	return s.secret
+}
+
+// setSecrets replaces the credentials of every secured route at once, so
+// a request sees either all of the old secrets or all of the new ones.
+func (s *server) setSecrets(secrets map[string]routeSecret) {
+	s.secretMu.Lock()
+	s.secret = secrets
+	s.secretMu.Unlock()
+}
+
+// checkSecrets reports secrets that can't be used with the auth scheme.
+func checkSecrets(scheme int, secrets map[string]routeSecret) error {
+	if scheme != authDigest {
+		return nil
+	}
+	for route, rs := range secrets {
+		for _, secret := range rs.passwords() {
+			if isHashedSecret(secret) {
+				return fmt.Errorf("secret for '%s' is a bcrypt hash, which requires 'auth_scheme: basic'", route)
+			}
+		}
+	}
+	return nil
+}
+
+// reloadSecrets replaces the secrets, including those of virtual hosts,
+// with those currently in the settings file. Other settings are left as
+// they are, and so is the nonce key, so authenticated clients stay
+// authenticated unless their credentials changed.
+func (s *server) reloadSecrets() error {
+	st, err := readSettings(s.settingsFile)
+	if err != nil {
+		return err
+	}
+	if err := checkSecrets(s.authScheme, st.Secrets); err != nil {
+		return err
+	}
+	vhostSecrets := make(map[string]map[string]routeSecret)
+	for _, v := range st.Vhosts {
+		if err := checkSecrets(s.authScheme, v.Secrets); err != nil {
+			return fmt.Errorf("vhost %s: %v", v.Host, err)
+		}
+		vhostSecrets[strings.ToLower(v.Host)] = v.Secrets
+	}
+	s.setSecrets(st.Secrets)
+	for host, vs := range s.vhosts {
+		vs.setSecrets(vhostSecrets[host])
+	}
+	return nil
+}
+
+// authExempt reports whether a path in a secured route is served without
+// authentication, like a favicon or the stylesheet of a login page.
+func (s *server) authExempt(pathStr string) bool {
+	for _, pattern := range s.authExemptions {
+		name := path.Base(pathStr)
+		if strings.HasPrefix(pattern, "/") {
+			name = pathStr
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authentication schemes for secured routes.
+const (
+	authDigest = iota
+	authBasic
+)
+
+// authSchemes maps the values of 'auth_scheme' to the schemes.
+var authSchemes = map[string]int{
+	"digest": authDigest,
+	"basic":  authBasic,
+}
+
+// checkAuth validates a request for proper authentication, given that the
+// route requires it (i.e. the route is a key in s.Secret). A Digest
+// response that is correct but uses an expired nonce is reported as stale.
+func (s *server) checkAuth(ctx *fasthttp.RequestCtx, route string) (ok, stale bool) {
+	h := strings.SplitN(string(ctx.Request.Header.Peek("Authorization")), " ", 2)
+	if s.authScheme == authBasic {
+		return len(h) == 2 && h[0] == "Basic" && s.checkBasic(h[1], route), false
+	}
+	if len(h) != 2 || h[0] != "Digest" {
+		return false, false
+	}
+	digest := parseHeader(h[1])
+	realm := s.host + `-` + route
+	if digest["realm"] != realm {
+		return false, false
+	}
+	nonce := digest["nonce"]
+	nc := digest["nc"]
+	cnonce := digest["cnonce"]
+	qop := digest["qop"]
+	rs, ok := s.secrets()[route]
+	if !ok {
+		return false, false
+	}
+	password, ok := rs.passwordFor(digest["username"])
+	if !ok {
+		return false, false
+	}
+	ha1b := md5.Sum([]byte(digest["username"] + ":" + realm + ":" + password))
+	a2 := fmt.Sprintf("%s:%s", ctx.Method(), ctx.Path())
+	if qop == "auth-int" {
+		// integrity protection covers the entity body
+		a2 += fmt.Sprintf(":%x", md5.Sum(ctx.PostBody()))
+	}
+	ha2b := md5.Sum([]byte(a2))
+	ha1 := fmt.Sprintf("%x", ha1b)
+	ha2 := fmt.Sprintf("%x", ha2b)
+	sd := strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":")
+	resb := md5.Sum([]byte(sd))
+	res := fmt.Sprintf("%x", resb)
+	if res != digest["response"] {
+		return false, false
+	}
+	valid, expired := s.checkNonce(nonce)
+	return valid && !expired, valid && expired
+}
+
+// newNonce creates a nonce carrying its creation time, authenticated by an
+// HMAC so that it can be verified without keeping state. Nothing is stored
+// per challenge, so issuing any number of them takes no memory.
+func (s *server) newNonce() string {
+	ts := strconv.FormatInt(time.Now().Unix(), 16)
+	return ts + "." + s.nonceMAC(ts)
+}
+
+func (s *server) nonceMAC(ts string) string {
+	mac := hmac.New(sha256.New, s.nonceKey)
+	mac.Write([]byte(ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkNonce reports whether a nonce was issued by this server, and if so
+// whether it is older than the nonce TTL.
+func (s *server) checkNonce(nonce string) (valid, expired bool) {
+	parts := strings.SplitN(nonce, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(s.nonceMAC(parts[0]))) {
+		return false, false
+	}
+	ts, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil {
+		return false, false
+	}
+	return true, time.Since(time.Unix(ts, 0)) > s.nonceTTL
+}
+
+// checkBasic validates the credentials of a Basic Authorization header.
+// As with Digest, the username is only affirmed for routes with users.
+func (s *server) checkBasic(credentials, route string) bool {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	userpass := strings.SplitN(string(b), ":", 2)
+	if len(userpass) != 2 {
+		return false
+	}
+	rs, ok := s.secrets()[route]
+	if !ok {
+		return false
+	}
+	secret, ok := rs.passwordFor(userpass[0])
+	if !ok {
+		return false
+	}
+	if isHashedSecret(secret) {
+		return bcrypt.CompareHashAndPassword([]byte(secret), []byte(userpass[1])) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(userpass[1]), []byte(secret)) == 1
+}
+
+// isHashedSecret reports whether a secret is a bcrypt hash rather than a
+// plaintext password. htpasswd -B writes hashes as $2y$.
+func isHashedSecret(secret string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(secret, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendChallenge sends an authentication request according to the Digest
+// Access Authentication scheme per RFC 2617 (or the Basic scheme per RFC
+// 7617, if configured) using the WWW-Authenticate header.
+func (s *server) sendChallenge(ctx *fasthttp.RequestCtx, route string, stale bool) {
+	realm := fmt.Sprintf(`realm="%s-%s"`, s.host, route)
+	if s.authScheme == authBasic {
+		ctx.Response.Header.Set("WWW-Authenticate", "Basic "+realm+`, charset="UTF-8"`)
+	} else {
+		s.setDigestChallenge(ctx, realm, stale)
+	}
+
+	ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
+	ctx.Response.SetBodyString("Unauthorized")
+	logRequest(ctx, fasthttp.StatusUnauthorized, "Unauthorized")
+}
+
+// setDigestChallenge sets the WWW-Authenticate header for Digest Access
+// Authentication. With stale set, clients retry with the new nonce without
+// prompting for the password again.
+func (s *server) setDigestChallenge(ctx *fasthttp.RequestCtx, realm string, stale bool) {
+	qop := `qop="auth,auth-int"`
+	nonce := fmt.Sprintf(`nonce="%s"`, s.newNonce())
+	challenge := strings.Join([]string{realm, qop, nonce}, ", ")
+	if stale {
+		challenge += ", stale=true"
+	}
+	ctx.Response.Header.Set("WWW-Authenticate", "Digest "+challenge)
+}
+
+// corsOrigin gives the Origin of a request if it's one allowed to make
+// credentialed cross-origin requests to secured routes.
+func (s *server) corsOrigin(ctx *fasthttp.RequestCtx) (string, bool) {
+	origin := string(ctx.Request.Header.Peek("Origin"))
+	if origin == "" {
+		return "", false
+	}
+	for _, allowed := range s.corsOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// setCORS lets an allowed origin read the response to a request for a
+// secured route, sent with credentials.
+func (s *server) setCORS(ctx *fasthttp.RequestCtx) bool {
+	origin, ok := s.corsOrigin(ctx)
+	if !ok {
+		return false
+	}
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", origin)
+	ctx.Response.Header.Set("Access-Control-Allow-Credentials", "true")
+	ctx.Response.Header.Add("Vary", "Origin")
+	return true
+}
+
+// sendPreflight answers a CORS preflight request for a secured route,
+// allowing the actual request only from the configured origins. The
+// actual request still has to authenticate.
+func (s *server) sendPreflight(ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.Set("Allow", "GET, HEAD, OPTIONS")
+	if s.setCORS(ctx) {
+		ctx.Response.Header.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		ctx.Response.Header.Set("Access-Control-Allow-Headers", "Authorization")
+	}
+	ctx.Response.SetStatusCode(fasthttp.StatusNoContent)
+	logRequest(ctx, fasthttp.StatusNoContent, "preflight")
+}
@@ -0,0 +1,263 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// digestAuthorization gives the Authorization header of a request
+// answering a Digest challenge, for qop "auth" or "auth-int".
+func digestAuthorization(challenge, method, uri, username, password, qop string, body []byte) string {
+	params := parseHeader(strings.TrimPrefix(challenge, "Digest "))
+	realm, nonce := params["realm"], params["nonce"]
+	nc, cnonce := "00000001", "0a4f113b"
+	ha1 := fmt.Sprintf("%x", md5.Sum([]byte(username+":"+realm+":"+password)))
+	a2 := method + ":" + uri
+	if qop == "auth-int" {
+		a2 += fmt.Sprintf(":%x", md5.Sum(body))
+	}
+	ha2 := fmt.Sprintf("%x", md5.Sum([]byte(a2)))
+	response := fmt.Sprintf("%x", md5.Sum([]byte(strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":"))))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=%s, nc=%s, cnonce="%s", response="%s"`,
+		username, realm, nonce, uri, qop, nc, cnonce, response)
+}
+
+// authGet answers a GET request for uri authenticated with Digest, after
+// getting a challenge, with the headers given as pairs of names and
+// values.
+func authGet(s *server, uri, username, password string, headers ...string) *fasthttp.Response {
+	challenge := string(get(s, uri, headers...).Header.Peek("WWW-Authenticate"))
+	auth := digestAuthorization(challenge, "GET", uri, username, password, "auth", nil)
+	return get(s, uri, append(headers, "Authorization", auth)...)
+}
+
+func TestCORS(t *testing.T) {
+	s := newTestServer(t, "secrets:\n  private: pw\ncors_origins: [https://app.example.com]\n", map[string]string{
+		"private/page.md": "secret",
+	})
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://evil.example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		req := new(fasthttp.Request)
+		req.Header.SetMethod("OPTIONS")
+		req.SetRequestURI("/private/page")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		preflight := serveRequest(s, req)
+		if preflight.StatusCode() != fasthttp.StatusNoContent {
+			t.Errorf("preflight from %q: got %d", tt.origin, preflight.StatusCode())
+		}
+		var headers []string
+		if tt.origin != "" {
+			headers = []string{"Origin", tt.origin}
+		}
+		actual := authGet(s, "/private/page", "any", "pw", headers...)
+		if actual.StatusCode() != fasthttp.StatusOK {
+			t.Errorf("GET from %q: got %d", tt.origin, actual.StatusCode())
+		}
+		for name, resp := range map[string]*fasthttp.Response{"preflight": preflight, "GET": actual} {
+			acao := string(resp.Header.Peek("Access-Control-Allow-Origin"))
+			acac := string(resp.Header.Peek("Access-Control-Allow-Credentials"))
+			vary := string(resp.Header.Peek("Vary"))
+			if tt.allowed && (acao != tt.origin || acac != "true" || !strings.Contains(vary, "Origin")) {
+				t.Errorf("%s from %q: got origin %q, credentials %q, vary %q", name, tt.origin, acao, acac, vary)
+			}
+			if !tt.allowed && (acao != "" || acac != "" || strings.Contains(vary, "Origin")) {
+				t.Errorf("%s from %q: allowed with origin %q, credentials %q, vary %q", name, tt.origin, acao, acac, vary)
+			}
+		}
+	}
+}
+
+func TestDigestAuthInt(t *testing.T) {
+	s := newTestServer(t, "secrets:\n  private: pw\n", map[string]string{
+		"private/page.md": "secret",
+	})
+	challenge := string(get(s, "/private/page").Header.Peek("WWW-Authenticate"))
+	if !strings.Contains(challenge, "auth-int") {
+		t.Fatalf("challenge doesn't offer auth-int: %q", challenge)
+	}
+	tests := []struct {
+		name       string
+		signedBody string
+		sentBody   string
+		want       int
+	}{
+		{"same body", "name=value", "name=value", fasthttp.StatusOK},
+		{"empty body", "", "", fasthttp.StatusOK},
+		{"changed body", "name=value", "name=other", fasthttp.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := new(fasthttp.Request)
+		req.Header.SetMethod("POST")
+		req.SetRequestURI("/private/page")
+		req.SetBodyString(tt.sentBody)
+		req.Header.Set("Authorization", digestAuthorization(challenge, "POST", "/private/page", "any", "pw", "auth-int", []byte(tt.signedBody)))
+		if got := serveRequest(s, req).StatusCode(); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+	s := newTestServer(t, "secrets:\n  private: pw\nauth_nonce_ttl: 5\n", map[string]string{
+		"private/page.md": "secret",
+	})
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 16)
+	fresh := s.newNonce()
+	flipped := fresh[:len(fresh)-1] + "0"
+	if strings.HasSuffix(fresh, "0") {
+		flipped = fresh[:len(fresh)-1] + "1"
+	}
+	tests := []struct {
+		name   string
+		nonce  string
+		status int
+		stale  bool
+	}{
+		{"fresh", fresh, fasthttp.StatusOK, false},
+		{"expired", old + "." + s.nonceMAC(old), fasthttp.StatusUnauthorized, true},
+		{"tampered time", old + fresh[strings.Index(fresh, "."):], fasthttp.StatusUnauthorized, false},
+		{"tampered MAC", flipped, fasthttp.StatusUnauthorized, false},
+		{"no MAC", strings.SplitN(fresh, ".", 2)[0], fasthttp.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		challenge := fmt.Sprintf(`Digest realm="%s-private", nonce="%s"`, s.host, tt.nonce)
+		resp := get(s, "/private/page", "Authorization", digestAuthorization(challenge, "GET", "/private/page", "any", "pw", "auth", nil))
+		if resp.StatusCode() != tt.status {
+			t.Errorf("%s nonce: got %d, want %d", tt.name, resp.StatusCode(), tt.status)
+		}
+		if stale := strings.Contains(string(resp.Header.Peek("WWW-Authenticate")), "stale=true"); stale != tt.stale {
+			t.Errorf("%s nonce: stale %v, want %v", tt.name, stale, tt.stale)
+		}
+	}
+}
+
+func TestRouteUsers(t *testing.T) {
+	s := newTestServer(t, "secrets:\n  team:\n    alice: alice_pw\n    bob: bob_pw\n  open: shared_pw\n", map[string]string{
+		"team/page.md": "team",
+		"open/page.md": "open",
+	})
+	tests := []struct {
+		uri, username, password string
+		want                    int
+	}{
+		{"/team/page", "alice", "alice_pw", fasthttp.StatusOK},
+		{"/team/page", "bob", "bob_pw", fasthttp.StatusOK},
+		{"/team/page", "alice", "bob_pw", fasthttp.StatusUnauthorized},
+		{"/team/page", "bob", "alice_pw", fasthttp.StatusUnauthorized},
+		{"/team/page", "carol", "alice_pw", fasthttp.StatusUnauthorized},
+		// a single password is for any username
+		{"/open/page", "anyone", "shared_pw", fasthttp.StatusOK},
+		{"/open/page", "anyone", "alice_pw", fasthttp.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := authGet(s, tt.uri, tt.username, tt.password).StatusCode(); got != tt.want {
+			t.Errorf("%s as %s:%s: got %d, want %d", tt.uri, tt.username, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestReloadSecretsConcurrently(t *testing.T) {
+	s := newTestServer(t, "secrets:\n  private: pw1\n", map[string]string{
+		"private/page.md": "secret",
+	})
+	s.settingsFile = fp.Join(s.path, ".settings.yaml")
+	challenge := string(get(s, "/private/page").Header.Peek("WWW-Authenticate"))
+	auth := digestAuthorization(challenge, "GET", "/private/page", "any", "pw1", "auth", nil)
+
+	// reloading unchanged credentials keeps clients authenticated, even
+	// with the nonce issued before
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if got := get(s, "/private/page", "Authorization", auth).StatusCode(); got != 200 {
+					t.Errorf("during reload: got %d", got)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		yml := fmt.Sprintf("secrets:\n  private: pw1\n  other%d: x\n", i)
+		writeFiles(t, s.path, map[string]string{".settings.yaml": yml})
+		if err := s.reloadSecrets(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	// changed credentials apply to the next request
+	writeFiles(t, s.path, map[string]string{".settings.yaml": "secrets:\n  private: pw2\n"})
+	if err := s.reloadSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(s, "/private/page", "Authorization", auth).StatusCode(); got != 401 {
+		t.Errorf("old password after reload: got %d, want 401", got)
+	}
+	if got := authGet(s, "/private/page", "any", "pw2").StatusCode(); got != 200 {
+		t.Errorf("new password after reload: got %d, want 200", got)
+	}
+}
+
+func TestHashedSecrets(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// htpasswd -B writes $2y$, the same hash under another name
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		secret := prefix + string(hash[4:])
+		if !isHashedSecret(secret) {
+			t.Errorf("%s: not taken for a hash", prefix)
+			continue
+		}
+		s := &server{secret: map[string]routeSecret{"private": {password: secret}}}
+		for password, want := range map[string]bool{"pw": true, "wrong": false, secret: false} {
+			credentials := base64.StdEncoding.EncodeToString([]byte("any:" + password))
+			if got := s.checkBasic(credentials, "private"); got != want {
+				t.Errorf("%s: password %q: got %v, want %v", prefix, password, got, want)
+			}
+		}
+		if err := checkSecrets(authDigest, s.secret); err == nil {
+			t.Errorf("%s: hash allowed with Digest", prefix)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/url"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// autoindexHandler creates the handler listing a directory without an
+// index, rendered in the directory's markdown template. Hidden files,
+// directory templates, and secured routes are left out, and rendered files
+// are linked by name without the extension.
+func (s *server) autoindexHandler(ctx *fasthttp.RequestCtx, dir string) (fasthttp.RequestHandler, int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, 0, &RenderError{dir, stageRead, err}
+	}
+	pathStr := string(ctx.Path())
+	if !strings.HasSuffix(pathStr, "/") {
+		pathStr += "/"
+	}
+	list := new(bytes.Buffer)
+	fmt.Fprintf(list, "<h1>Index of %s</h1>\n<ul>\n", html.EscapeString(pathStr))
+	for _, fi := range files {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") || !fi.IsDir() && isDirTemplate(name) {
+			continue
+		}
+		link, size := name, formatSize(fi.Size())
+		if fi.IsDir() {
+			link, name, size = name+"/", name+"/", "-"
+		} else if ext := strings.TrimPrefix(fp.Ext(name), "."); s.renderable[ext] {
+			link = strings.TrimSuffix(name, "."+ext)
+		}
+		if pathStr == "/" {
+			// listings are cached for everyone, so routes that need
+			// authentication aren't given away
+			if _, isSecret := s.secrets()[strings.TrimSuffix(link, "/")]; isSecret {
+				continue
+			}
+		}
+		fmt.Fprintf(list, "<li><a href=\"%s\">%s</a> %s</li>\n", (&url.URL{Path: link}).EscapedPath(), html.EscapeString(name), size)
+	}
+	list.WriteString("</ul>\n")
+	content := &templateContent{
+		Content: list.String(),
+		Title:   "Index of " + pathStr,
+		Query:   s.templateQuery(ctx),
+	}
+	if base, ok := ctx.UserValue("base").(string); ok {
+		content.Base = base
+	}
+	buf := new(bytes.Buffer)
+	tpl := s.dirTemplate(fp.Join(dir, "index"))
+	if variant := s.templateVariant(ctx); variant != "" {
+		tpl = s.templates[variant]
+	}
+	if err := tpl.Execute(buf, content); err != nil {
+		return nil, 0, &RenderError{dir, stageTemplate, err}
+	}
+	out := buf.Bytes()
+	return handlerReader("autoindex "+dir, bytes.NewReader(out)), len(out), nil
+}
+
+// formatSize gives a file size in bytes in a readable unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestAutoindex(t *testing.T) {
+	files := map[string]string{
+		"docs/guide.md":    "guide",
+		"docs/notes.txt":   "notes",
+		"docs/.hidden.md":  "hidden",
+		"docs/sub/page.md": "page",
+		"private/page.md":  "secret",
+		"page.md":          "page",
+	}
+	tests := []struct {
+		uri      string
+		contains []string
+		excludes []string
+	}{
+		{
+			uri:      "/docs/",
+			contains: []string{`<a href="guide">guide.md</a>`, `<a href="notes.txt">notes.txt</a> 5 B`, `<a href="sub/">sub/</a> -`},
+			excludes: []string{"hidden"},
+		},
+		{
+			uri:      "/",
+			contains: []string{`<a href="docs/">docs/</a>`, `<a href="page">page.md</a>`},
+			excludes: []string{"private", "settings"},
+		},
+	}
+	yml := "secrets:\n  private: pw\n"
+	s := newTestServer(t, yml+"autoindex: true\n", files)
+	for _, tt := range tests {
+		resp := get(s, tt.uri)
+		body := string(resp.Body())
+		if resp.StatusCode() != fasthttp.StatusOK {
+			t.Errorf("GET %s: got %d", tt.uri, resp.StatusCode())
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s: got %q, want it to contain %q", tt.uri, body, want)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("GET %s: got %q, listing %q", tt.uri, body, unwanted)
+			}
+		}
+	}
+	if resp := get(s, "/docs"); resp.StatusCode() != fasthttp.StatusMovedPermanently || !strings.HasSuffix(string(resp.Header.Peek("Location")), "/docs/") {
+		t.Errorf("GET /docs: got %d to %q", resp.StatusCode(), resp.Header.Peek("Location"))
+	}
+
+	s = newTestServer(t, yml+"autoindex: false\n", files)
+	for _, tt := range tests {
+		if got := get(s, tt.uri).StatusCode(); got != fasthttp.StatusNotFound {
+			t.Errorf("GET %s without autoindex: got %d, want 404", tt.uri, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.n); got != tt.want {
+			t.Errorf("formatSize(%d): got %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	fp "path/filepath"
+	"strings"
+)
+
+// renderAsciidoc renders an AsciiDoc file to HTML with the configured
+// asciidoctor command, without the header and footer of a standalone
+// document but with its title.
+func (s *server) renderAsciidoc(filename string) ([]byte, error) {
+	return convert([]string{s.asciidoctor, "-s", "-a", "showtitle", "-o", "-"}, filename)
+}
+
+// renderRST renders a reStructuredText file to HTML with the configured
+// converter command.
+func (s *server) renderRST(filename string) ([]byte, error) {
+	return convert(s.rstCommand, filename)
+}
+
+// convert runs a converter command with a file's name as its last
+// argument, giving what it writes to stdout. It runs in the file's
+// directory, so includes resolve relative to the file.
+func convert(command []string, filename string) ([]byte, error) {
+	args := append(command[1:len(command):len(command)], fp.Base(filename))
+	cmd := exec.Command(command[0], args...)
+	cmd.Dir = fp.Dir(filename)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
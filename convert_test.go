@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	fp "path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// mockConverter writes a shell script standing in for a converter
+// command, giving its path. The script notes each run in a file next to
+// it, named with ".runs" appended.
+func mockConverter(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("converters are mocked with shell scripts")
+	}
+	name := fp.Join(t.TempDir(), "convert")
+	script = "#!/bin/sh\necho run >> \"$0.runs\"\n" + script
+	if err := ioutil.WriteFile(name, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// converterRuns gives how many times a mocked converter ran.
+func converterRuns(t *testing.T, name string) int {
+	t.Helper()
+	runs, err := ioutil.ReadFile(name + ".runs")
+	if err != nil {
+		return 0
+	}
+	return strings.Count(string(runs), "run")
+}
+
+func TestAsciidoc(t *testing.T) {
+	asciidoctor := mockConverter(t, `
+if [ "$1 $2 $3 $4 $5" != "-s -a showtitle -o -" ]; then
+	echo "unexpected arguments: $*" >&2
+	exit 2
+fi
+if grep -q BROKEN "$6"; then
+	echo "broken document" >&2
+	exit 1
+fi
+sed -e 's|^= \(.*\)|<h1>\1</h1>|' -e 's|^\* \(.*\)|<li>\1</li>|' "$6"
+`)
+	s := newTestServer(t, "asciidoctor: "+asciidoctor+"\nttl: 5\ntemplate: tpl.html\n", map[string]string{
+		"tpl.html":        "<title>{{ .Title }}</title><main>{{ .Content }}</main>",
+		"guide.adoc":      "= Guide\n* one\n* two\n",
+		"other.asciidoc":  "= Other\n",
+		"broken.adoc":     "BROKEN\n",
+		"docs/inner.adoc": "= Inner\n",
+	})
+	tests := []struct {
+		uri      string
+		status   int
+		contains []string
+	}{
+		{"/guide", fasthttp.StatusOK, []string{"<title>Guide</title>", "<main><h1>Guide</h1>\n<li>one</li>\n<li>two</li>\n</main>"}},
+		{"/other", fasthttp.StatusOK, []string{"<title>Other</title>"}},
+		// run in the file's directory, given its name
+		{"/docs/inner", fasthttp.StatusOK, []string{"<title>Inner</title>"}},
+		{"/broken", fasthttp.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		resp := get(s, tt.uri)
+		body := string(resp.Body())
+		if resp.StatusCode() != tt.status {
+			t.Errorf("GET %s: got %d, want %d: %q", tt.uri, resp.StatusCode(), tt.status, body)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s: got %q, want it to contain %q", tt.uri, body, want)
+			}
+		}
+	}
+
+	// cached like markdown
+	runs := converterRuns(t, asciidoctor)
+	get(s, "/guide")
+	if got := converterRuns(t, asciidoctor); got != runs {
+		t.Errorf("cached page rendered again: %d runs, want %d", got, runs)
+	}
+}
+
+func TestRST(t *testing.T) {
+	converter := mockConverter(t, `
+if [ "$1" != "--template={body}" ]; then
+	echo "unexpected arguments: $*" >&2
+	exit 2
+fi
+if grep -q BROKEN "$2"; then
+	echo "broken document" >&2
+	exit 1
+fi
+sed -n -e '1s|.*|<h1>&</h1>|p' -e 's|^- \(.*\)|<li>\1</li>|p' "$2"
+`)
+	s := newTestServer(t, "rst_command: "+converter+" --template={body}\nttl: 5\ntemplate: tpl.html\n", map[string]string{
+		"tpl.html":   "<title>{{ .Title }}</title><main>{{ .Content }}</main>",
+		"page.rst":   "Page\n====\n\n- one\n- two\n",
+		"broken.rst": "BROKEN\n",
+		"other.adoc": "= Not rendered\n",
+	})
+	tests := []struct {
+		uri      string
+		status   int
+		contains string
+	}{
+		{"/page", fasthttp.StatusOK, "<title>Page</title><main><h1>Page</h1>\n<li>one</li>\n<li>two</li>\n</main>"},
+		{"/broken", fasthttp.StatusInternalServerError, ""},
+		// without asciidoctor set, adoc files are literal
+		{"/other.adoc", fasthttp.StatusOK, "= Not rendered"},
+	}
+	for _, tt := range tests {
+		resp := get(s, tt.uri)
+		body := string(resp.Body())
+		if resp.StatusCode() != tt.status || !strings.Contains(body, tt.contains) {
+			t.Errorf("GET %s: got %d %q, want %d with %q", tt.uri, resp.StatusCode(), body, tt.status, tt.contains)
+		}
+	}
+
+	runs := converterRuns(t, converter)
+	get(s, "/page")
+	if got := converterRuns(t, converter); got != runs {
+		t.Errorf("cached page rendered again: %d runs, want %d", got, runs)
+	}
+
+	if exited, out := toServerExits(t, "render: [md, rst]\n", nil); !exited || !strings.Contains(out, "rst_command") {
+		t.Errorf("rendering rst without rst_command: exited %v: %q", exited, out)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// preloadTypes maps extensions of critical assets to the destination of
+// their preload.
+var preloadTypes = map[string]string{
+	".css":   "style",
+	".js":    "script",
+	".mjs":   "script",
+	".woff":  "font",
+	".woff2": "font",
+	".png":   "image",
+	".jpg":   "image",
+	".jpeg":  "image",
+	".svg":   "image",
+	".webp":  "image",
+}
+
+// preloadLink gives the Link header value that preloads an asset.
+func preloadLink(asset string) string {
+	as, ok := preloadTypes[strings.ToLower(path.Ext(asset))]
+	if !ok {
+		as = "fetch"
+	}
+	link := fmt.Sprintf("<%s>; rel=preload; as=%s", asset, as)
+	if as == "font" || as == "fetch" {
+		// these are always fetched in CORS mode
+		link += "; crossorigin"
+	}
+	return link
+}
+
+// sendEarlyHints sends a 103 Early Hints response preloading the critical
+// assets, ahead of rendering a page. The links stay on the final response
+// too. HTTP/1.0 clients don't get them, since they can't expect an
+// informational response.
+func (s *server) sendEarlyHints(ctx *fasthttp.RequestCtx) {
+	if len(s.earlyHints) == 0 || !ctx.Request.Header.IsHTTP11() {
+		return
+	}
+	for _, link := range s.earlyHints {
+		ctx.Response.Header.Add("Link", link)
+	}
+	// adapted contexts have no connection to write to
+	if send, ok := ctx.UserValue("earlyHints").(func([]string)); ok {
+		send(s.earlyHints)
+		return
+	}
+	if err := ctx.EarlyHints(); err != nil {
+		log.Printf("couldn't send early hints for %s: %v", ctx.Path(), err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestPreloadLink(t *testing.T) {
+	tests := []struct {
+		asset, want string
+	}{
+		{"/css/site.css", "</css/site.css>; rel=preload; as=style"},
+		{"/js/app.JS", "</js/app.JS>; rel=preload; as=script"},
+		{"/fonts/body.woff2", "</fonts/body.woff2>; rel=preload; as=font; crossorigin"},
+		{"/img/logo.svg", "</img/logo.svg>; rel=preload; as=image"},
+		{"/data/index.json", "</data/index.json>; rel=preload; as=fetch; crossorigin"},
+	}
+	for _, tt := range tests {
+		if got := preloadLink(tt.asset); got != tt.want {
+			t.Errorf("preloadLink(%q): got %q, want %q", tt.asset, got, tt.want)
+		}
+	}
+}
+
+// rawGet sends a raw request for uri to a fasthttp server running the
+// handler of s, and gives everything written back.
+func rawGet(t *testing.T, s *server, uri, proto string) string {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go (&fasthttp.Server{Handler: s.handler()}).Serve(ln)
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET " + uri + " " + proto + "\r\nHost: localhost\r\nConnection: close\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestEarlyHints(t *testing.T) {
+	s := newTestServer(t, "early_hints: [/css/site.css, /fonts/body.woff2]\n", map[string]string{
+		"page.md":      "hello",
+		"css/site.css": "body {}",
+	})
+	links := []string{
+		"Link: </css/site.css>; rel=preload; as=style\r\n",
+		"Link: </fonts/body.woff2>; rel=preload; as=font; crossorigin\r\n",
+	}
+	tests := []struct {
+		uri, proto string
+		hints      bool
+	}{
+		{"/page", "HTTP/1.1", true},
+		// literal files aren't rendered, so there's nothing to wait for
+		{"/css/site.css", "HTTP/1.1", false},
+		{"/page", "HTTP/1.0", false},
+	}
+	for _, tt := range tests {
+		out := rawGet(t, s, tt.uri, tt.proto)
+		hints := strings.HasPrefix(out, "HTTP/1.1 103 Early Hints\r\n")
+		if hints != tt.hints {
+			t.Errorf("GET %s over %s: early hints %v, want %v: %q", tt.uri, tt.proto, hints, tt.hints, out)
+			continue
+		}
+		if !hints {
+			continue
+		}
+		i := strings.Index(out, "HTTP/1.1 200 OK\r\n")
+		if i < 0 {
+			t.Errorf("GET %s: no final response: %q", tt.uri, out)
+			continue
+		}
+		// the links are in the informational response and the final one
+		for _, link := range links {
+			if !strings.Contains(out[:i], link) || !strings.Contains(out[i:], link) {
+				t.Errorf("GET %s: missing %q: %q", tt.uri, link, out)
+			}
+		}
+	}
+}
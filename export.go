@@ -0,0 +1,209 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// refreshPage is the page a redirect is exported as, since a static host
+// can't answer with the redirect itself.
+const refreshPage = `<!doctype html><html>
+<head><meta charset="utf-8"><meta http-equiv="refresh" content="0; url=%[1]s"><link rel="canonical" href="%[1]s"></head>
+<body><a href="%[1]s">%[1]s</a></body>
+</html>`
+
+// export writes the site into out as static files, for hosting without
+// servemd. Pages are rendered with their templates and redirects become
+// pages that refresh to their target, both named the way link_style links
+// to them, while literal files are copied. Hidden files and directories,
+// directory templates, and secured routes are left out.
+func (s *server) export(out string) error {
+	out, err := fp.Abs(out)
+	if err != nil {
+		return err
+	}
+	if s.singleFile {
+		return s.exportPage(s.path, fp.Join(out, "index.html"), "/", s.language)
+	}
+	return fp.Walk(s.path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p == out || p != s.path && strings.HasPrefix(info.Name(), ".") {
+			return fp.SkipDir
+		}
+		rel, err := fp.Rel(s.path, p)
+		if err != nil {
+			return err
+		}
+		if p != s.path && s.skipSecured(path.Join("/", fp.ToSlash(rel))) {
+			return fp.SkipDir
+		}
+		return s.exportDir(p, fp.Join(out, rel), path.Join("/", fp.ToSlash(rel)))
+	})
+}
+
+// exportDir exports the files of a directory, which is served at urlDir,
+// into out. Of the pages and redirects sharing a name, only the one that
+// would be served is exported, and the index the same way as index.html.
+func (s *server) exportDir(dir, out, urlDir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	ctx := new(fasthttp.RequestCtx)
+	var index string
+	for _, name := range s.indexNames {
+		if index, _, _ = s.matchName(ctx, files, name); index != "" {
+			break
+		}
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || isDirTemplate(file.Name()) {
+			continue
+		}
+		if file.Mode()&os.ModeSymlink != 0 {
+			if s.noFollowSymlinks {
+				continue
+			}
+			// linked directories aren't walked
+			if fi, err := os.Stat(fp.Join(dir, file.Name())); err != nil || fi.IsDir() {
+				continue
+			}
+		}
+		ext := fp.Ext(file.Name())
+		if s.rendered(ext) {
+			if name := strings.TrimSuffix(file.Name(), ext); !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			continue
+		}
+		if s.skipSecured(path.Join(urlDir, file.Name())) {
+			continue
+		}
+		if err := copyFile(fp.Join(dir, file.Name()), fp.Join(out, file.Name())); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		filename, lang, _ := s.matchName(ctx, files, name)
+		if !s.rendered(fp.Ext(filename)) {
+			// a literal file is served for the name, and was copied
+			continue
+		}
+		if s.skipSecured(path.Join(urlDir, name)) {
+			continue
+		}
+		target := fp.Join(out, name+".html")
+		switch {
+		case filename == index:
+			target = fp.Join(out, "index.html")
+		case s.linkStyle != linkStyleHTML:
+			target = fp.Join(out, name, "index.html")
+		}
+		if err := s.exportPage(fp.Join(dir, filename), target, path.Join(urlDir, name), lang); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipSecured reports whether a path is in a secured route, which isn't
+// exported since a static host can't ask for its credentials, logging
+// what's left out.
+func (s *server) skipSecured(urlPath string) bool {
+	route := routeOf(urlPath)
+	if _, isSecret := s.secrets()[route]; !isSecret {
+		return false
+	}
+	log.Printf("not exporting %s, in secured route %s", urlPath, route)
+	return true
+}
+
+// rendered reports whether files with the extension are exported rendered
+// rather than copied.
+func (s *server) rendered(ext string) bool {
+	ext = strings.TrimPrefix(ext, ".")
+	_, isPage := pageKinds[ext]
+	return s.renderable[ext] && (isPage || ext == "redirect")
+}
+
+// exportPage writes a page or redirect, served at urlPath in lang, to
+// target as HTML.
+func (s *server) exportPage(filename, target, urlPath, lang string) error {
+	ext := strings.TrimPrefix(fp.Ext(filename), ".")
+	var out []byte
+	if ext == "redirect" {
+		url, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		escaped := html.EscapeString(redirectTarget(string(url)))
+		out = []byte(fmt.Sprintf(refreshPage, escaped))
+	} else {
+		ctx := new(fasthttp.RequestCtx)
+		ctx.Request.SetRequestURI(urlPath)
+		ctx.SetUserValue("lang", lang)
+		var err error
+		if out, err = s.renderFile(ctx, filename, ext); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(fp.Dir(target), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, out, 0644)
+}
+
+// copyFile copies a file's content to a new file.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
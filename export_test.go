@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	s := newTestServer(t, "template: .tpl.html\nsecrets:\n  private: pw\n  notes.txt: pw\n", map[string]string{
+		".tpl.html":        "<main>{{ .Content }}</main>",
+		"index.md":         "home",
+		"about.md":         "about",
+		"about.txt":        "about, literally",
+		"style.css":        "body {}",
+		"old.redirect":     "/about",
+		".hidden.md":       "hidden",
+		"docs/index.md":    "docs",
+		"docs/guide.md":    "guide",
+		"docs/layout.html": "{{ .Content }}",
+		"private.md":       "secret",
+		"private/page.md":  "secret",
+		"notes.txt":        "secret",
+	})
+	out := t.TempDir()
+	var err error
+	logged := captureLog(func() { err = s.export(out) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, contains string
+	}{
+		{"index.html", "<main><p>home</p>\n</main>"},
+		{"about/index.html", "<main><p>about</p>\n</main>"},
+		{"about.txt", "about, literally"},
+		{"style.css", "body {}"},
+		{"old/index.html", `http-equiv="refresh" content="0; url=/about"`},
+		{"docs/index.html", "<p>docs</p>"},
+		{"docs/guide/index.html", "<p>guide</p>"},
+	}
+	for _, tt := range tests {
+		b, err := ioutil.ReadFile(fp.Join(out, fp.FromSlash(tt.name)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !strings.Contains(string(b), tt.contains) {
+			t.Errorf("%s: got %q, want it to contain %q", tt.name, b, tt.contains)
+		}
+	}
+	for _, name := range []string{".tpl.html", ".hidden.md", ".hidden", "docs/layout.html", "private", "private.md", "notes.txt", "index.md"} {
+		if _, err := os.Stat(fp.Join(out, fp.FromSlash(name))); err == nil {
+			t.Errorf("%s exported", name)
+		}
+	}
+	for _, skipped := range []string{"/private,", "/notes.txt,"} {
+		if !strings.Contains(logged, "not exporting "+skipped) {
+			t.Errorf("skipping %s not logged: %q", skipped, logged)
+		}
+	}
+}
+
+func TestExportLinkStyleHTML(t *testing.T) {
+	s := newTestServer(t, "link_style: html\n", map[string]string{
+		"about.md":      "about",
+		"docs/guide.md": "guide",
+	})
+	out := t.TempDir()
+	if err := s.export(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"about.html", "docs/guide.html"} {
+		if _, err := os.Stat(fp.Join(out, fp.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+}
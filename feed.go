@@ -0,0 +1,280 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// feedDateLayouts are the accepted formats of a front matter date.
+var feedDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// feedItem is a markdown file listed in the feed.
+type feedItem struct {
+	title       string
+	description string
+	link        string
+	date        time.Time
+}
+
+// feed generates an RSS or Atom feed from the front matter of the markdown
+// files under a directory, regenerating it when they change.
+type feed struct {
+	path   string // the route it is served at
+	title  string
+	dir    string
+	limit  int
+	format string // "rss" or "atom"
+
+	mu        sync.Mutex
+	signature string
+	body      []byte
+}
+
+// serveFeed answers requests for the feed, reporting whether the request
+// was one. Its links are on the configured host, so it is cached like a
+// page until its directory changes.
+func (s *server) serveFeed(ctx *fasthttp.RequestCtx) bool {
+	if s.feed == nil || string(ctx.Path()) != s.feed.path {
+		return false
+	}
+	key := s.feedKey()
+	if s.cacheable(ctx) && !noCache(ctx) {
+		h, ok := s.cache.Get(key)
+		s.metrics.cacheLookup(ok)
+		if ok && s.cacheSizes != nil {
+			s.cacheSizes.touch(key)
+		}
+		if ok {
+			h.(fasthttp.RequestHandler)(ctx)
+			return true
+		}
+	}
+	scheme := "http"
+	if s.tls.port != "" {
+		scheme = "https"
+	}
+	body, modified, err := s.feed.generate(fmt.Sprintf("%s://%s", scheme, s.host), s.path, s.secrets())
+	if err != nil {
+		handlerInternalError(err, s.debug)(ctx)
+		return true
+	}
+	h := s.feedHandler(body, modified)
+	if s.cacheable(ctx) {
+		s.cacheStore(key, h, len(body))
+	}
+	h(ctx)
+	return true
+}
+
+// feedKey is the cache key of the feed.
+func (s *server) feedKey() string {
+	return s.vhost + s.feed.path
+}
+
+// feedHandler creates the handler serving a generated feed.
+func (s *server) feedHandler(body []byte, modified time.Time) fasthttp.RequestHandler {
+	etag := fmt.Sprintf(`"%x-%x"`, modified.UnixNano(), len(body))
+	contentType := "application/rss+xml; charset=utf-8"
+	if s.feed.format == "atom" {
+		contentType = "application/atom+xml; charset=utf-8"
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		if checkETag(ctx, etag) {
+			return
+		}
+		ctx.SetContentType(contentType)
+		ctx.SetBody(body)
+		logRequest(ctx, fasthttp.StatusOK, "feed "+s.feed.dir)
+	}
+}
+
+// feedChanged evicts the cached feed if a file changed under its
+// directory.
+func (s *server) feedChanged(filename string) {
+	if s.feed == nil || s.cache == nil {
+		return
+	}
+	if rel, err := fp.Rel(s.feed.dir, filename); err == nil && !strings.HasPrefix(rel, "..") {
+		s.cache.Delete(s.feedKey())
+	}
+}
+
+// generate gives the feed, along with when its newest file was modified.
+// It is only regenerated when a file was added, removed, or modified.
+// Files in secured routes are left out, since the feed isn't.
+func (f *feed) generate(base, root string, secrets map[string]routeSecret) ([]byte, time.Time, error) {
+	var files []string
+	var modified time.Time
+	err := fp.Walk(f.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(p, ".md") {
+			return nil
+		}
+		rel, err := fp.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		if _, isSecret := secrets[routeOf("/"+fp.ToSlash(rel))]; isSecret {
+			return nil
+		}
+		files = append(files, p)
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, modified, err
+	}
+	signature := fmt.Sprintf("%s %d-%d", base, len(files), modified.UnixNano())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if signature == f.signature {
+		return f.body, modified, nil
+	}
+
+	var items []feedItem
+	for _, filename := range files {
+		md, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		meta, _ := splitFrontMatter(filename, md)
+		item := feedItem{
+			title:       fmt.Sprint(meta["title"]),
+			description: fmt.Sprint(meta["description"]),
+			date:        feedDate(meta["date"]),
+		}
+		if meta["title"] == nil || item.date.IsZero() {
+			// only dated, titled files are posts
+			continue
+		}
+		if meta["description"] == nil {
+			item.description = ""
+		}
+		rel, _ := fp.Rel(root, strings.TrimSuffix(filename, ".md"))
+		item.link = base + "/" + fp.ToSlash(rel)
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].date.After(items[j].date) })
+	if f.limit > 0 && len(items) > f.limit {
+		items = items[:f.limit]
+	}
+
+	var body []byte
+	if f.format == "atom" {
+		body, err = f.atom(base, items, modified)
+	} else {
+		body, err = f.rss(base, items, modified)
+	}
+	if err != nil {
+		return nil, modified, err
+	}
+	f.signature, f.body = signature, body
+	return body, modified, nil
+}
+
+// feedDate parses a front matter date, giving the zero time if it is
+// missing or invalid.
+func feedDate(v interface{}) time.Time {
+	switch date := v.(type) {
+	case time.Time:
+		return date
+	case string:
+		for _, layout := range feedDateLayouts {
+			if t, err := time.Parse(layout, date); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (f *feed) rss(base string, items []feedItem, modified time.Time) ([]byte, error) {
+	type rssItem struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		GUID        string `xml:"guid"`
+		PubDate     string `xml:"pubDate"`
+		Description string `xml:"description,omitempty"`
+	}
+	type rss struct {
+		XMLName xml.Name  `xml:"rss"`
+		Version string    `xml:"version,attr"`
+		Title   string    `xml:"channel>title"`
+		Link    string    `xml:"channel>link"`
+		Desc    string    `xml:"channel>description"`
+		Updated string    `xml:"channel>lastBuildDate"`
+		Items   []rssItem `xml:"channel>item"`
+	}
+	doc := rss{Version: "2.0", Title: f.title, Link: base + "/", Desc: f.title, Updated: modified.UTC().Format(time.RFC1123Z)}
+	for _, item := range items {
+		doc.Items = append(doc.Items, rssItem{item.title, item.link, item.link, item.date.UTC().Format(time.RFC1123Z), item.description})
+	}
+	return marshalFeed(doc)
+}
+
+func (f *feed) atom(base string, items []feedItem, modified time.Time) ([]byte, error) {
+	type atomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+	type atomEntry struct {
+		Title   string   `xml:"title"`
+		Link    atomLink `xml:"link"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Summary string   `xml:"summary,omitempty"`
+	}
+	type atom struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string      `xml:"title"`
+		ID      string      `xml:"id"`
+		Links   []atomLink  `xml:"link"`
+		Updated string      `xml:"updated"`
+		Entries []atomEntry `xml:"entry"`
+	}
+	doc := atom{
+		Title:   f.title,
+		ID:      base + f.path,
+		Links:   []atomLink{{base + "/", ""}, {base + f.path, "self"}},
+		Updated: modified.UTC().Format(time.RFC3339),
+	}
+	for _, item := range items {
+		doc.Entries = append(doc.Entries, atomEntry{item.title, atomLink{item.link, ""}, item.link, item.date.UTC().Format(time.RFC3339), item.description})
+	}
+	return marshalFeed(doc)
+}
+
+func marshalFeed(doc interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	fp "path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const feedSettings = "host: blog.example.com\nttl: 5\nfeed:\n  path: /feed.xml\n  dir: posts\n"
+
+func TestFeedLinks(t *testing.T) {
+	s := newTestServer(t, feedSettings, map[string]string{
+		"posts/first.md": "---\ntitle: First\ndate: 2024-01-02\n---\ntext\n",
+	})
+	for _, host := range []string{"blog.example.com", "evil.example.com"} {
+		body := string(get(s, "/feed.xml", "Host", host).Body())
+		if !strings.Contains(body, "<link>http://blog.example.com/posts/first</link>") {
+			t.Errorf("Host %s: got %q", host, body)
+		}
+		if strings.Contains(body, "evil") {
+			t.Errorf("Host %s: the feed links to the request's host", host)
+		}
+	}
+}
+
+func TestFeedCache(t *testing.T) {
+	s := newTestServer(t, feedSettings, map[string]string{
+		"posts/first.md": "---\ntitle: First\ndate: 2024-01-02\n---\ntext\n",
+		"page.md":        "not a post",
+	})
+	get(s, "/feed.xml")
+	if _, ok := s.cache.Get("/feed.xml"); !ok {
+		t.Fatal("feed isn't cached")
+	}
+	second := fp.Join(s.path, "posts", "second.md")
+	writeFiles(t, s.path, map[string]string{"posts/second.md": "---\ntitle: Second\ndate: 2024-02-03\n---\ntext\n"})
+	if body := string(get(s, "/feed.xml").Body()); strings.Contains(body, "Second") {
+		t.Error("cached feed was regenerated without a change event")
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		evicted bool
+	}{
+		{"outside the feed", fp.Join(s.path, "page.md"), false},
+		{"in the feed", second, true},
+	}
+	for _, tt := range tests {
+		s.contentChanged(nil, fsnotify.Event{Name: tt.file, Op: fsnotify.Write})
+		if _, ok := s.cache.Get("/feed.xml"); ok == tt.evicted {
+			t.Errorf("%s: evicted %v, want %v", tt.name, !ok, tt.evicted)
+		}
+	}
+	if body := string(get(s, "/feed.xml").Body()); !strings.Contains(body, "Second") {
+		t.Errorf("regenerated feed is missing the new post: %q", body)
+	}
+}
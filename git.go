@@ -0,0 +1,160 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	fp "path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// gitContent keeps the served directory a working copy of a branch of a
+// git repository, using the git command.
+type gitContent struct {
+	mu     sync.Mutex
+	dir    string
+	repo   string
+	branch string
+	poll   time.Duration // never polled if zero
+
+	// webhook is the path of requests that trigger a pull, signed with
+	// secret. It's off if empty.
+	webhook string
+	secret  string
+}
+
+func (g *gitContent) git(args ...string) (string, error) {
+	out, err := exec.Command("git", append([]string{"-C", g.dir}, args...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// sync clones the repository if the directory isn't a working copy yet,
+// or else fetches the branch and resets to it, discarding local changes.
+// It reports whether the content changed.
+func (g *gitContent) sync() (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := os.Stat(fp.Join(g.dir, ".git")); os.IsNotExist(err) {
+		out, err := exec.Command("git", "clone", "--branch", g.branch, "--single-branch", g.repo, g.dir).CombinedOutput()
+		if err != nil {
+			return false, fmt.Errorf("git clone: %v: %s", err, bytes.TrimSpace(out))
+		}
+		return true, nil
+	}
+	before, _ := g.git("rev-parse", "HEAD")
+	if _, err := g.git("fetch", "origin", g.branch); err != nil {
+		return false, err
+	}
+	if _, err := g.git("reset", "--hard", "FETCH_HEAD"); err != nil {
+		return false, err
+	}
+	after, err := g.git("rev-parse", "HEAD")
+	return before != after, err
+}
+
+// pullGit updates the working copy, flushing the cache if the content
+// changed.
+func (s *server) pullGit(reason string) error {
+	changed, err := s.git.sync()
+	if err != nil {
+		log.Printf("%s, couldn't pull git content: %v", reason, err)
+		return err
+	}
+	if changed {
+		s.flushCache(reason + ", git content changed")
+	}
+	return nil
+}
+
+// pollGit pulls the working copy at the poll interval.
+func (s *server) pollGit() {
+	go func() {
+		for range time.Tick(s.git.poll) {
+			s.pullGit("polled git")
+		}
+	}()
+}
+
+// serveGitWebhook answers requests for the git webhook path, reporting
+// whether the request was one. A request signed with the webhook secret
+// pulls the working copy before it's answered.
+func (s *server) serveGitWebhook(ctx *fasthttp.RequestCtx) bool {
+	if s.git == nil || s.git.webhook == "" || string(ctx.Path()) != s.git.webhook {
+		return false
+	}
+	if !ctx.IsPost() {
+		ctx.Response.Header.Set("Allow", "POST")
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		logRequest(ctx, fasthttp.StatusMethodNotAllowed, "git webhook")
+		return true
+	}
+	if !validSignature(s.git.secret, ctx.PostBody(), ctx.Request.Header.Peek("X-Hub-Signature-256")) {
+		ctx.Error("Unauthorized", fasthttp.StatusUnauthorized)
+		logRequest(ctx, fasthttp.StatusUnauthorized, "git webhook")
+		return true
+	}
+	if err := s.pullGit("git webhook"); err != nil {
+		handlerInternalError(err, s.debug)(ctx)
+		return true
+	}
+	ctx.Response.Header.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString("pulled\n")
+	logRequest(ctx, fasthttp.StatusOK, "git webhook")
+	return true
+}
+
+// commitInfo describes the last commit of a file. Without git, only Date
+// is known, from the file's modification time.
+type commitInfo struct {
+	Hash    string
+	Author  string
+	Date    time.Time
+	Message string
+}
+
+// commitOf gives the last commit of a file, remembered until the cache is
+// flushed so git isn't run for every render. If there's no commit, from
+// git not being available or the file not being committed, it gives the
+// modification time instead.
+func (s *server) commitOf(filename string) *commitInfo {
+	if info, ok := s.commits.Load(filename); ok {
+		return info.(*commitInfo)
+	}
+	info := new(commitInfo)
+	out, err := exec.Command("git", "-C", fp.Dir(filename), "log", "-1", "--format=%H%x00%an%x00%aI%x00%s", "--", fp.Base(filename)).Output()
+	fields := strings.SplitN(strings.TrimSpace(string(out)), "\x00", 4)
+	if err == nil && len(fields) == 4 {
+		info.Hash, info.Author, info.Message = fields[0], fields[1], fields[3]
+		info.Date, _ = time.Parse(time.RFC3339, fields[2])
+	} else if fi, err := os.Stat(filename); err == nil {
+		info.Date = fi.ModTime()
+	}
+	s.commits.Store(filename, info)
+	return info
+}
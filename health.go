@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serveHealth answers liveness and readiness probes, reporting whether the
+// request was one. Probes skip TLS redirects, authentication, and path
+// resolution so a load balancer can reach them over plain HTTP.
+func (s *server) serveHealth(ctx *fasthttp.RequestCtx) bool {
+	pathStr := string(ctx.Path())
+	switch {
+	case s.health.live != "" && pathStr == s.health.live:
+		sendHealth(ctx, fasthttp.StatusOK, "ok")
+	case s.health.ready != "" && pathStr == s.health.ready:
+		if s.ready() {
+			sendHealth(ctx, fasthttp.StatusOK, "ok")
+		} else {
+			sendHealth(ctx, fasthttp.StatusServiceUnavailable, "unavailable")
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// ready reports whether the templates were parsed and the served
+// directory, or file, can be read.
+func (s *server) ready() bool {
+	if !s.health.templatesOK {
+		return false
+	}
+	dir, err := os.Open(s.path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	if s.singleFile {
+		return true
+	}
+	_, err = dir.Readdirnames(1)
+	return err == nil || err == io.EOF
+}
+
+func sendHealth(ctx *fasthttp.RequestCtx, code int, status string) {
+	ctx.Response.SetStatusCode(code)
+	ctx.Response.Header.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetBodyString(`{"status":"` + status + `"}`)
+}
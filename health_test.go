@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+const healthSettings = "health:\n  live: /healthz\n  ready: /readyz\n"
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		files    map[string]string
+		remove   bool // the served directory is removed
+		live     int
+		ready    int
+	}{
+		{"healthy", healthSettings, nil, false, 200, 200},
+		{"broken template", healthSettings + "template: tpl.html\n", map[string]string{"tpl.html": "{{ if }}"}, false, 200, 503},
+		{"unreadable dir", healthSettings, nil, true, 200, 503},
+		{"secured and TLS only", healthSettings + "host: example.com\nsecrets:\n  healthz: pw\n  readyz: pw\ntls:\n  cert: cert.pem\n  privkey: key.pem\n  required: all\n",
+			map[string]string{"cert.pem": "", "key.pem": ""}, false, 200, 200},
+		{"disabled", "", nil, false, 404, 404},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, tt.settings, tt.files)
+		if tt.remove {
+			if err := os.RemoveAll(s.path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for path, want := range map[string]int{"/healthz": tt.live, "/readyz": tt.ready} {
+			resp := get(s, path)
+			if resp.StatusCode() != want {
+				t.Errorf("%s: GET %s: got %d, want %d", tt.name, path, resp.StatusCode(), want)
+				continue
+			}
+			body := map[int]string{200: `{"status":"ok"}`, 503: `{"status":"unavailable"}`}[want]
+			if want != 404 && (string(resp.Body()) != body || string(resp.Header.ContentType()) != "application/json") {
+				t.Errorf("%s: GET %s: got %q as %q", tt.name, path, resp.Body(), resp.Header.ContentType())
+			}
+		}
+	}
+}
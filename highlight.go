@@ -0,0 +1,138 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// codePattern matches code blocks without a language, as both markdown
+// engines render them.
+var codePattern = regexp.MustCompile(`(?s)<pre><code>(.*?)</code></pre>`)
+
+// languageHints are patterns suggesting a code block's language, each
+// match counting once toward it.
+var languageHints = map[string][]*regexp.Regexp{
+	"go": {
+		regexp.MustCompile(`(?m)^package \w+$`),
+		regexp.MustCompile(`\bfunc (\(\w+ \*?\w+\) )?\w+\(`),
+		regexp.MustCompile(`\w+ := `),
+		regexp.MustCompile(`\bfmt\.\w+\(`),
+	},
+	"python": {
+		regexp.MustCompile(`(?m)^\s*def \w+\(.*\):$`),
+		regexp.MustCompile(`(?m)^(from \w+(\.\w+)* )?import \w+`),
+		regexp.MustCompile(`\bself\.\w+`),
+		regexp.MustCompile(`(?m)^\s*(elif|except)\b`),
+		regexp.MustCompile(`\bprint\(`),
+	},
+	"javascript": {
+		regexp.MustCompile(`\bfunction\s*\w*\(`),
+		regexp.MustCompile(`\b(const|let) \w+ = `),
+		regexp.MustCompile(`\) => `),
+		regexp.MustCompile(`\bconsole\.\w+\(`),
+		regexp.MustCompile(`\brequire\(['"]`),
+	},
+	"bash": {
+		regexp.MustCompile(`(?m)^\$ \w`),
+		regexp.MustCompile(`(?m)^\s*(sudo|echo|export|cd|apt-get|curl) `),
+		regexp.MustCompile(`(?m)^\s*(fi|done|esac)$`),
+		regexp.MustCompile(`\$\{\w+\}`),
+	},
+	"sql": {
+		regexp.MustCompile(`(?i)\bselect\b.+\bfrom\b`),
+		regexp.MustCompile(`(?i)\binsert into\b`),
+		regexp.MustCompile(`(?i)\bcreate table\b`),
+		regexp.MustCompile(`(?i)\bwhere\b.+=`),
+	},
+	"css": {
+		regexp.MustCompile(`(?m)^[.#]?[\w-]+( [.#]?[\w-]+)*\s*\{$`),
+		regexp.MustCompile(`(?m)^\s*[\w-]+: [^;]+;$`),
+	},
+	"yaml": {
+		regexp.MustCompile(`(?m)^[\w-]+:( [^{};]*)?$`),
+		regexp.MustCompile(`(?m)^\s+- [\w-]+`),
+	},
+	"html": {
+		regexp.MustCompile(`(?i)<!doctype html|<html\b`),
+		regexp.MustCompile(`<(div|span|p|a|body|head|script)\b[^>]*>`),
+	},
+}
+
+var shebangs = map[string]string{
+	"bash":   "bash",
+	"sh":     "bash",
+	"python": "python",
+	"node":   "javascript",
+}
+
+// detectLanguages gives rendered HTML with a language class on code blocks
+// that have none, from their content, so client-side highlighters like
+// Prism pick them up. Blocks whose language isn't clear are left alone.
+func detectLanguages(out []byte) []byte {
+	return codePattern.ReplaceAllFunc(out, func(block []byte) []byte {
+		code := html.UnescapeString(string(codePattern.FindSubmatch(block)[1]))
+		lang := detectLanguage(code)
+		if lang == "" {
+			return block
+		}
+		return []byte(`<pre><code class="language-` + lang + `">` + string(block[len("<pre><code>"):]))
+	})
+}
+
+// detectLanguage guesses the language of code by its shebang, by being
+// JSON, or else by the language with the most hints. The guess has to
+// have at least two hints and more than any other language, or none is
+// given.
+func detectLanguage(code string) string {
+	trimmed := strings.TrimSpace(code)
+	if strings.HasPrefix(trimmed, "#!") {
+		line := strings.Fields(strings.SplitN(trimmed, "\n", 2)[0])
+		for _, field := range line {
+			name := field[strings.LastIndex(field, "/")+1:]
+			if lang, ok := shebangs[name]; ok {
+				return lang
+			}
+		}
+	}
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	best, bestScore, runnerUp := "", 0, 0
+	for lang, hints := range languageHints {
+		score := 0
+		for _, hint := range hints {
+			if hint.MatchString(code) {
+				score++
+			}
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore, runnerUp = lang, score, bestScore
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	if bestScore < 2 || bestScore == runnerUp {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/valyala/fasthttp"
+)
+
+// serveHTTP3 runs an HTTP/3 server over QUIC on the TLS port, sharing the
+// routing of ServeHTTP through a net/http adapter.
+func (s *server) serveHTTP3(handler fasthttp.RequestHandler) {
+	log.Printf("starting HTTP/3 server on %s", s.addr(s.tls.port))
+	srv := s.http3Server(handler)
+	if s.tls.acme != nil {
+		log.Fatal(srv.ListenAndServe())
+	}
+	log.Fatal(srv.ListenAndServeTLS(s.tls.cert, s.tls.key))
+}
+
+// http3Server creates the HTTP/3 server, with the same timeouts as the
+// fasthttp server. Certificates come from the ACME manager if there is
+// one.
+func (s *server) http3Server(handler fasthttp.RequestHandler) *http3.Server {
+	srv := &http3.Server{
+		Addr:        s.addr(s.tls.port),
+		Handler:     s.netHTTPHandler(handler, true),
+		IdleTimeout: s.timeouts.idle,
+	}
+	if s.tls.acme != nil {
+		srv.TLSConfig = s.tls.acme.TLSConfig()
+	}
+	return srv
+}
+
+// altSvc is the Alt-Svc header value advertising the HTTP/3 server.
+func (s *server) altSvc() string {
+	return fmt.Sprintf(`h3=":%s"; ma=86400`, s.tls.port)
+}
+
+// netHTTPHandler adapts a fasthttp handler to net/http. Request bodies are
+// limited to the maximum body size, and reading the request and writing
+// the response are bounded by the read and write timeouts, as fasthttp
+// does for its own connections. Requests
+// through it are marked as TLS if secure is set, since the adapted context
+// has no connection of its own.
+func (s *server) netHTTPHandler(h fasthttp.RequestHandler, secure bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if s.timeouts.read > 0 {
+			// writers without deadlines just aren't bounded
+			rc.SetReadDeadline(time.Now().Add(s.timeouts.read))
+		}
+		var req fasthttp.Request
+		req.Header.SetMethod(r.Method)
+		req.SetRequestURI(r.URL.RequestURI())
+		req.Header.SetHost(r.Host)
+		for key, values := range r.Header {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+		if r.Body != nil {
+			limit := s.maxBodySize
+			if limit == 0 {
+				limit = fasthttp.DefaultMaxRequestBodySize
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(limit)))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.SetBody(body)
+		}
+		remoteAddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+		if err != nil {
+			remoteAddr = &net.TCPAddr{}
+		}
+
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, remoteAddr, nil)
+		if secure {
+			ctx.SetUserValue("tls", true)
+		}
+		ctx.SetUserValue("earlyHints", func(links []string) {
+			for _, link := range links {
+				w.Header().Add("Link", link)
+			}
+			w.WriteHeader(http.StatusEarlyHints)
+			// the final response copies them again
+			w.Header().Del("Link")
+		})
+		h(&ctx)
+
+		if s.timeouts.write > 0 {
+			rc.SetWriteDeadline(time.Now().Add(s.timeouts.write))
+		}
+		ctx.Response.Header.VisitAll(func(key, value []byte) {
+			if string(key) != "Content-Length" {
+				w.Header().Add(string(key), string(value))
+			}
+		})
+		w.WriteHeader(ctx.Response.StatusCode())
+		if r.Method != http.MethodHead {
+			ctx.Response.BodyWriteTo(w)
+		}
+	})
+}
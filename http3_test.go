@@ -0,0 +1,99 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// http3Settings enables HTTP/3, with certificate files that are only read
+// when listening, so empty ones do.
+const http3Settings = "tls:\n  cert: cert.pem\n  privkey: key.pem\n  http3: true\n"
+
+func TestHTTP3Server(t *testing.T) {
+	s := newTestServer(t, http3Settings+"timeouts:\n  read: 5\n  write: 10\n  idle: 30\n", map[string]string{
+		"page.md": "hello", "cert.pem": "", "key.pem": "",
+	})
+	srv := s.http3Server(s.handler())
+	if srv.IdleTimeout != 30*time.Second {
+		t.Errorf("idle timeout is %v, want 30s", srv.IdleTimeout)
+	}
+	if !strings.HasSuffix(srv.Addr, ":443") {
+		t.Errorf("listening on %s, want the TLS port", srv.Addr)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/page", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hello") {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Alt-Svc") == "" {
+		t.Error("HTTP/3 response isn't treated as TLS, no Alt-Svc header")
+	}
+}
+
+func TestHTTP3ReadTimeout(t *testing.T) {
+	s := newTestServer(t, http3Settings+"timeouts:\n  read: 1\n", map[string]string{
+		"page.md": "hello", "cert.pem": "", "key.pem": "",
+	})
+	ts := httptest.NewServer(s.netHTTPHandler(s.handler(), true))
+	defer ts.Close()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the body is never finished
+	conn.Write([]byte("POST /page HTTP/1.1\r\nHost: example.com\r\nContent-Length: 100\r\n\r\npartial"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("no response after the read timeout: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHTTP3BodyLimit(t *testing.T) {
+	s := newTestServer(t, http3Settings+"max_body_size: 10\n", map[string]string{
+		"page.md": "hello", "cert.pem": "", "key.pem": "",
+	})
+	h := s.netHTTPHandler(s.handler(), true)
+	tests := []struct {
+		body string
+		want int
+	}{
+		{"short", http.StatusOK},
+		{"0123456789", http.StatusOK},
+		{"01234567890", http.StatusRequestEntityTooLarge},
+		{strings.Repeat("x", 1<<20), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "https://example.com/page", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%d byte body: got %d, want %d", len(tt.body), w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
+	"os"
+	"path"
+	fp "path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	imgPattern  = regexp.MustCompile(`<img\b([^>]*?)(\s*/?>)`)
+	srcPattern  = regexp.MustCompile(`\bsrc="([^"]*)"`)
+	sizePattern = regexp.MustCompile(`\b(?:width|height)=`)
+)
+
+// imageDims are the dimensions of an image file as of its modification
+// time. They are zero if it couldn't be decoded.
+type imageDims struct {
+	modTime       time.Time
+	width, height int
+}
+
+// addImageSizes gives rendered HTML with width and height attributes on
+// images that are local files, so browsers can lay out the page before
+// the images load. Images that already have a size, are remote, or aren't
+// GIF, JPEG, or PNG are left alone.
+func (s *server) addImageSizes(filename string, out []byte) []byte {
+	return imgPattern.ReplaceAllFunc(out, func(img []byte) []byte {
+		m := imgPattern.FindSubmatch(img)
+		attrs := string(m[1])
+		src := srcPattern.FindStringSubmatch(attrs)
+		if src == nil || sizePattern.MatchString(attrs) {
+			return img
+		}
+		file := s.imageFile(filename, html.UnescapeString(src[1]))
+		if file == "" {
+			return img
+		}
+		dims := s.dimsOf(file)
+		if dims.width == 0 {
+			return img
+		}
+		return []byte(fmt.Sprintf(`<img%s width="%d" height="%d"%s`, attrs, dims.width, dims.height, m[2]))
+	})
+}
+
+// imageFile gives the file of an image's source in a page, or the empty
+// string if it is remote or outside the served directory and mounts.
+func (s *server) imageFile(filename, src string) string {
+	if src == "" || strings.HasPrefix(src, "//") || strings.Contains(strings.SplitN(src, "/", 2)[0], ":") {
+		return ""
+	}
+	if i := strings.IndexAny(src, "?#"); i >= 0 {
+		src = src[:i]
+	}
+	p, err := url.PathUnescape(src)
+	if err != nil || p == "" {
+		return ""
+	}
+	var root, file string
+	if strings.HasPrefix(p, "/") {
+		root, file = s.resolve(path.Clean(p))
+	} else {
+		// relative sources resolve against the page's URL, which is in
+		// the same directory as its file
+		root = s.rootOf(fp.Dir(filename))
+		file = fp.Join(fp.Dir(filename), fp.FromSlash(p))
+	}
+	if root == "" || !s.contained(root, file) {
+		return ""
+	}
+	return file
+}
+
+// dimsOf gives the dimensions of an image file, decoding it once per
+// modification.
+func (s *server) dimsOf(file string) imageDims {
+	fi, err := os.Stat(file)
+	if err != nil || fi.IsDir() {
+		return imageDims{}
+	}
+	if v, ok := s.images.Load(file); ok && v.(imageDims).modTime.Equal(fi.ModTime()) {
+		return v.(imageDims)
+	}
+	dims := imageDims{modTime: fi.ModTime()}
+	if f, err := os.Open(file); err == nil {
+		if cfg, _, err := image.DecodeConfig(f); err == nil {
+			dims.width, dims.height = cfg.Width, cfg.Height
+		}
+		f.Close()
+	}
+	s.images.Store(file, dims)
+	return dims
+}
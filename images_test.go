@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pngOf gives a blank PNG image of a size.
+func pngOf(t *testing.T, width, height int) string {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestImageSizes(t *testing.T) {
+	files := map[string]string{
+		"img/wide.png":  pngOf(t, 40, 10),
+		"docs/tall.png": pngOf(t, 10, 40),
+		"img/fake.png":  "not an image",
+		"outside.png":   pngOf(t, 5, 5),
+	}
+	tests := []struct {
+		md, want string
+	}{
+		{"![a](/img/wide.png)", `<img src="/img/wide.png" alt="a" width="40" height="10"`},
+		{"![a](tall.png)", `<img src="tall.png" alt="a" width="10" height="40"`},
+		{"![a](tall.png?v=2#x)", `<img src="tall.png?v=2#x" alt="a" width="10" height="40"`},
+		{"![a](../img/wide.png)", `<img src="../img/wide.png" alt="a" width="40" height="10"`},
+		{`<img src="/img/wide.png" width="20">`, `<img src="/img/wide.png" width="20">`},
+		{"![a](https://example.com/img/wide.png)", `<img src="https://example.com/img/wide.png" alt="a" />`},
+		{"![a](//example.com/img/wide.png)", `<img src="//example.com/img/wide.png" alt="a" />`},
+		{"![a](/img/fake.png)", `<img src="/img/fake.png" alt="a" />`},
+		{"![a](/img/missing.png)", `<img src="/img/missing.png" alt="a" />`},
+		{"![a](../../outside.png)", `<img src="../../outside.png" alt="a" />`},
+	}
+	for _, sizes := range []bool{true, false} {
+		yml := fmt.Sprintf("dir: site\nmarkdown:\n  image_sizes: %v\n", sizes)
+		pages := map[string]string{"outside.png": files["outside.png"]}
+		for name, content := range files {
+			if name != "outside.png" {
+				pages["site/"+name] = content
+			}
+		}
+		for i, tt := range tests {
+			pages[fmt.Sprintf("site/docs/page%d.md", i)] = tt.md
+		}
+		s := newTestServer(t, yml, pages)
+		for i, tt := range tests {
+			body := string(get(s, fmt.Sprintf("/docs/page%d", i)).Body())
+			if sizes && !strings.Contains(body, tt.want) {
+				t.Errorf("%s: got %q, want it to contain %q", tt.md, body, tt.want)
+			}
+			if !sizes && strings.Contains(body, "height=") {
+				t.Errorf("%s without image_sizes: got %q", tt.md, body)
+			}
+		}
+	}
+}
+
+func TestImageDimsChange(t *testing.T) {
+	s := newTestServer(t, "", map[string]string{"a.png": pngOf(t, 3, 4)})
+	file := fp.Join(s.path, "a.png")
+	if dims := s.dimsOf(file); dims.width != 3 || dims.height != 4 {
+		t.Fatalf("got %dx%d, want 3x4", dims.width, dims.height)
+	}
+	writeFiles(t, s.path, map[string]string{"a.png": pngOf(t, 7, 8)})
+	// dimensions are remembered until the modification time changes
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if dims := s.dimsOf(file); dims.width != 7 || dims.height != 8 {
+		t.Errorf("after change: got %dx%d, want 7x8", dims.width, dims.height)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	fp "path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/valyala/fasthttp"
+)
+
+// langPattern matches the language segment of a localized file name, like
+// "fr" in "page.fr.md" or "pt-BR" in "page.pt-BR.md".
+var langPattern = regexp.MustCompile(`^[a-z]{2}(-[A-Za-z]{2})?$`)
+
+// acceptedLanguages gives the languages of a request's Accept-Language
+// header, lowercased and most preferred first.
+func acceptedLanguages(ctx *fasthttp.RequestCtx) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(string(ctx.Request.Header.Peek("Accept-Language")), ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// extRank gives the precedence of a file extension, lower first. Listed
+// extensions come before the rest.
+func (s *server) extRank(ext string) int {
+	for i, e := range s.extensions {
+		if strings.EqualFold(e, ext) {
+			return i
+		}
+	}
+	return len(s.extensions)
+}
+
+// prefer tells whether file a should serve a request over file b, by the
+// precedence of their extensions and then alphabetically, so the choice
+// doesn't depend on the order of a directory listing.
+func (s *server) prefer(a, b string) bool {
+	ra, rb := s.extRank(fp.Ext(a)), s.extRank(fp.Ext(b))
+	if ra != rb {
+		return ra < rb
+	}
+	return a < b
+}
+
+// matchName gives the file in a directory listing that serves a request
+// for name, the one named name.* whose extension comes first, along with
+// its language. With a default language configured, localized files named
+// name.<lang>.* are negotiated by Accept-Language, falling back to the
+// default language and then to the unlocalized file. The languages name is
+// localized in are also given, in the order they're negotiated.
+func (s *server) matchName(ctx *fasthttp.RequestCtx, files []os.FileInfo, name string) (filename, lang string, langs []string) {
+	plain := ""
+	localized := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir() || isDirTemplate(file.Name()) {
+			continue
+		}
+		ext := fp.Ext(file.Name())
+		pref := strings.TrimSuffix(file.Name(), ext)
+		if pref == name {
+			if plain == "" || s.prefer(file.Name(), plain) {
+				plain = file.Name()
+			}
+			continue
+		}
+		if s.language == "" || !strings.HasPrefix(pref, name+".") {
+			continue
+		}
+		l := strings.TrimPrefix(pref, name+".")
+		if !langPattern.MatchString(l) {
+			continue
+		}
+		l = strings.ToLower(l)
+		if f, ok := localized[l]; !ok {
+			localized[l] = file.Name()
+			langs = append(langs, l)
+		} else if s.prefer(file.Name(), f) {
+			localized[l] = file.Name()
+		}
+	}
+	lang = s.negotiate(ctx, langs)
+	if f, ok := localized[lang]; ok {
+		return f, lang, langs
+	}
+	return plain, s.language, langs
+}
+
+// negotiate gives the language of langs that best matches a request's
+// Accept-Language, or else the default language.
+func (s *server) negotiate(ctx *fasthttp.RequestCtx, langs []string) string {
+	if len(langs) == 0 {
+		return s.language
+	}
+	for _, tag := range acceptedLanguages(ctx) {
+		for _, l := range langs {
+			if l == tag {
+				return l
+			}
+		}
+		// a language also matches its regional variants, and vice versa
+		primary := strings.SplitN(tag, "-", 2)[0]
+		for _, l := range langs {
+			if strings.SplitN(l, "-", 2)[0] == primary {
+				return l
+			}
+		}
+	}
+	return s.language
+}
+
+// languagesKey is the cache key of the languages a path was found
+// localized in, which cacheKey negotiates between before the path is
+// resolved again.
+func (s *server) languagesKey(pathStr string) string {
+	return s.vhost + pathStr + "?languages"
+}
+
+// rememberLanguages caches the languages the path of a request was found
+// localized in, until it changes or the cache is flushed.
+func (s *server) rememberLanguages(ctx *fasthttp.RequestCtx, langs []string) {
+	if s.language != "" && s.cacheable(ctx) {
+		s.cache.Set(s.languagesKey(string(ctx.Path())), langs, cache.DefaultExpiration)
+	}
+}
+
+// cachedLanguage gives the language negotiated for a request, or the empty
+// string if the languages of its path aren't known yet.
+func (s *server) cachedLanguage(ctx *fasthttp.RequestCtx) string {
+	if lang, ok := ctx.UserValue("lang").(string); ok {
+		return lang
+	}
+	langs, ok := s.cache.Get(s.languagesKey(string(ctx.Path())))
+	if !ok {
+		return ""
+	}
+	return s.negotiate(ctx, langs.([]string))
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLanguageCacheKeys(t *testing.T) {
+	s := newTestServer(t, "ttl: 5\nlanguage: en\n", map[string]string{
+		"page.en.md": "english",
+		"page.fr.md": "french",
+		"plain.md":   "plain",
+	})
+	tests := []struct {
+		path, accept, want, key string
+	}{
+		{"/page", "fr", "french", "/page?lang=fr"},
+		{"/page", "fr-CA, en;q=0.5", "french", "/page?lang=fr"},
+		{"/page", "de, fr;q=0.8", "french", "/page?lang=fr"},
+		{"/page", "de", "english", "/page?lang=en"},
+		{"/page", "", "english", "/page?lang=en"},
+		{"/plain", "fr", "plain", "/plain?lang=en"},
+		{"/plain", "de", "plain", "/plain?lang=en"},
+	}
+	// the second time around, pages come from the cache
+	for i := 0; i < 2; i++ {
+		for _, tt := range tests {
+			var headers []string
+			if tt.accept != "" {
+				headers = []string{"Accept-Language", tt.accept}
+			}
+			if body := string(get(s, tt.path, headers...).Body()); !strings.Contains(body, tt.want) {
+				t.Errorf("GET %s in %q: got %q, want %q", tt.path, tt.accept, body, tt.want)
+			}
+			if _, ok := s.cache.Get(tt.key); !ok {
+				t.Errorf("GET %s in %q: not cached as %s", tt.path, tt.accept, tt.key)
+			}
+		}
+	}
+	// one page per language served, and the languages of each path
+	if n := s.cache.ItemCount(); n != 5 {
+		t.Errorf("%d items cached, want 5", n)
+	}
+}
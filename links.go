@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Styles of internal links in rendered output.
+const (
+	linkStyleClean = "clean" // /about/ and /about, as servemd resolves them
+	linkStyleIndex = "index" // /about/index.html
+	linkStyleHTML  = "html"  // /about/index.html and /about.html
+)
+
+var hrefPattern = regexp.MustCompile(`\bhref=("[^"]*"|'[^']*')`)
+
+// rewriteLinks gives rendered output with its internal links in the
+// configured style, so they work on a static host that doesn't resolve
+// clean URLs.
+func (s *server) rewriteLinks(out []byte) []byte {
+	if s.linkStyle == "" || s.linkStyle == linkStyleClean {
+		return out
+	}
+	return hrefPattern.ReplaceAllFunc(out, func(attr []byte) []byte {
+		quoted := string(attr[len("href="):])
+		quote, link := quoted[:1], quoted[1:len(quoted)-1]
+		return []byte("href=" + quote + styleLink(link, s.linkStyle) + quote)
+	})
+}
+
+// unstyleLink gives the path servemd resolves for a request path in the
+// configured link style, like /about for /about.html, and whether it is
+// one.
+func (s *server) unstyleLink(pathStr string) (string, bool) {
+	switch {
+	case s.linkStyle == "" || s.linkStyle == linkStyleClean:
+		return pathStr, false
+	case strings.HasSuffix(pathStr, "/index.html"):
+		return strings.TrimSuffix(pathStr, "index.html"), true
+	case s.linkStyle == linkStyleHTML && strings.HasSuffix(pathStr, ".html"):
+		return strings.TrimSuffix(pathStr, ".html"), true
+	}
+	return pathStr, false
+}
+
+// styleLink rewrites an internal link to the style, leaving external
+// links, fragments, and links to files with an extension alone.
+func styleLink(link, style string) string {
+	if link == "" || strings.HasPrefix(link, "#") || strings.HasPrefix(link, "//") || strings.Contains(strings.SplitN(link, "/", 2)[0], ":") {
+		return link
+	}
+	p, rest := link, ""
+	if i := strings.IndexAny(link, "?#"); i >= 0 {
+		p, rest = link[:i], link[i:]
+	}
+	if p == "" {
+		return link
+	}
+	base := path.Base(p)
+	switch {
+	case strings.HasSuffix(p, "/"):
+		p += "index.html"
+	case base == "." || base == "..":
+		p += "/index.html"
+	case path.Ext(base) != "":
+		return link
+	case style == linkStyleHTML:
+		p += ".html"
+	default:
+		p += "/index.html"
+	}
+	return p + rest
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStyleLink(t *testing.T) {
+	tests := []struct {
+		link, index, html string
+	}{
+		{"/about", "/about/index.html", "/about.html"},
+		{"/about/", "/about/index.html", "/about/index.html"},
+		{"about", "about/index.html", "about.html"},
+		{"../about", "../about/index.html", "../about.html"},
+		{"..", "../index.html", "../index.html"},
+		{".", "./index.html", "./index.html"},
+		{"/about?x=1#top", "/about/index.html?x=1#top", "/about.html?x=1#top"},
+		{"/about/#top", "/about/index.html#top", "/about/index.html#top"},
+		{"/style.css", "/style.css", "/style.css"},
+		{"#top", "#top", "#top"},
+		{"?x=1", "?x=1", "?x=1"},
+		{"", "", ""},
+		{"https://example.com/about", "https://example.com/about", "https://example.com/about"},
+		{"mailto:me@example.com", "mailto:me@example.com", "mailto:me@example.com"},
+		{"//example.com/about", "//example.com/about", "//example.com/about"},
+	}
+	for _, tt := range tests {
+		if got := styleLink(tt.link, linkStyleIndex); got != tt.index {
+			t.Errorf("index %q: got %q, want %q", tt.link, got, tt.index)
+		}
+		if got := styleLink(tt.link, linkStyleHTML); got != tt.html {
+			t.Errorf("html %q: got %q, want %q", tt.link, got, tt.html)
+		}
+	}
+}
+
+func TestLinkStyleRendered(t *testing.T) {
+	files := map[string]string{"page.md": "[about](/about) [docs](/docs/) [css](/style.css) [out](https://example.com/x)"}
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{"clean", `<a href="/about">about</a> <a href="/docs/">docs</a>`},
+		{"index", `<a href="/about/index.html">about</a> <a href="/docs/index.html">docs</a>`},
+		{"html", `<a href="/about.html">about</a> <a href="/docs/index.html">docs</a>`},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "link_style: "+tt.style+"\n", files)
+		body := string(get(s, "/page").Body())
+		if !strings.Contains(body, tt.want) || !strings.Contains(body, `href="/style.css"`) || !strings.Contains(body, `href="https://example.com/x"`) {
+			t.Errorf("%s: got %q, want %q", tt.style, body, tt.want)
+		}
+	}
+}
+
+func TestLinkStyleFollowed(t *testing.T) {
+	files := map[string]string{
+		"index.md":      "[about](/about) [docs](/docs/) [guide](docs/guide) [old](/old)",
+		"about.md":      "about page",
+		"docs/index.md": "docs index",
+		"docs/guide.md": "guide page",
+		"old.redirect":  "/about",
+		"notes.html":    "literal notes",
+		"style.css":     "body {}",
+	}
+	tests := []struct {
+		style string
+		links map[string]string // from the rendered index to what they serve
+	}{
+		{"index", map[string]string{
+			"/about/index.html":     "about page",
+			"/docs/index.html":      "docs index",
+			"docs/guide/index.html": "guide page",
+			"/old/index.html":       "",
+		}},
+		{"html", map[string]string{
+			"/about.html":      "about page",
+			"/docs/index.html": "docs index",
+			"docs/guide.html":  "guide page",
+			"/old.html":        "",
+		}},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "link_style: "+tt.style+"\n", files)
+		body := string(get(s, "/").Body())
+		for link, want := range tt.links {
+			if !strings.Contains(body, `href="`+link+`"`) {
+				t.Errorf("%s: index doesn't link %s: %q", tt.style, link, body)
+				continue
+			}
+			if !strings.HasPrefix(link, "/") {
+				link = "/" + link
+			}
+			resp := get(s, link)
+			switch {
+			case want == "" && resp.StatusCode() != 308:
+				t.Errorf("%s: GET %s: got %d, want a redirect", tt.style, link, resp.StatusCode())
+			case want != "" && (resp.StatusCode() != 200 || !strings.Contains(string(resp.Body()), want)):
+				t.Errorf("%s: GET %s: got %d %q, want %q", tt.style, link, resp.StatusCode(), resp.Body(), want)
+			}
+		}
+		// files by those names still win, and only pages are found
+		if body := string(get(s, "/notes.html").Body()); body != "literal notes" {
+			t.Errorf("%s: GET /notes.html: got %q", tt.style, body)
+		}
+		if got := get(s, "/style.html").StatusCode(); got != 404 {
+			t.Errorf("%s: GET /style.html: got %d, want 404", tt.style, got)
+		}
+	}
+	// clean links are the only ones resolved by default
+	s := newTestServer(t, "", files)
+	if got := get(s, "/about.html").StatusCode(); got != 404 {
+		t.Errorf("clean: GET /about.html: got %d, want 404", got)
+	}
+}
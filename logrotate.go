@@ -0,0 +1,116 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// rotatingFile is a log file that is rotated once it would grow past its
+// maximum size, keeping a number of numbered backups: server.log becomes
+// server.log.1, server.log.1 becomes server.log.2, and so on.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64 // no rotation by size if zero
+	backups int
+	f       *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.f, r.size = f, fi.Size()
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't rotate log file %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the log file regardless of its size.
+func (r *rotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// rotate shifts the backups, dropping the oldest, and reopens a fresh
+// file. If the fresh file can't be opened, writes continue to the old one.
+func (r *rotatingFile) rotate() error {
+	for i := r.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.backups > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(r.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	r.f.Close()
+	r.f, r.size = f, 0
+	return nil
+}
+
+// watchRotate rotates the log file on SIGUSR2.
+func (r *rotatingFile) watchRotate() {
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Signal(syscall.SIGUSR2))
+	go func() {
+		for {
+			<-sc
+			if err := r.Rotate(); err != nil {
+				log.Printf("received SIGUSR2, log not rotated: %v", err)
+			} else {
+				log.Printf("received SIGUSR2, log has been rotated")
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"testing"
+)
+
+// logLines gives the content of a log file with the numbered lines, each
+// 40 bytes long.
+func logLines(lines ...int) string {
+	out := ""
+	for _, i := range lines {
+		out += fmt.Sprintf("%-39d\n", i)
+	}
+	return out
+}
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		backups int
+		writes  int // lines, past a 100 byte maximum after 2
+		active  string
+		kept    []string // backups, newest first
+	}{
+		{"under the maximum", 2, 2, logLines(0, 1), nil},
+		{"past the maximum", 2, 3, logLines(2), []string{logLines(0, 1)}},
+		{"several times", 2, 7, logLines(6), []string{logLines(4, 5), logLines(2, 3)}},
+		{"oldest dropped", 1, 7, logLines(6), []string{logLines(4, 5)}},
+		{"no backups", 0, 3, logLines(2), nil},
+	}
+	for _, tt := range tests {
+		name := fp.Join(t.TempDir(), "server.log")
+		r, err := openRotatingFile(name, 100, tt.backups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < tt.writes; i++ {
+			if _, err := r.Write([]byte(logLines(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.Close()
+		if got, _ := ioutil.ReadFile(name); string(got) != tt.active {
+			t.Errorf("%s: active file has %q, want %q", tt.name, got, tt.active)
+		}
+		for i := 0; i <= tt.backups; i++ {
+			backup := fmt.Sprintf("%s.%d", name, i+1)
+			got, err := ioutil.ReadFile(backup)
+			switch {
+			case i >= len(tt.kept) && !os.IsNotExist(err):
+				t.Errorf("%s: unexpected backup %s", tt.name, backup)
+			case i < len(tt.kept) && string(got) != tt.kept[i]:
+				t.Errorf("%s: backup %d has %q, want %q", tt.name, i+1, got, tt.kept[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cacheSizes tracks the sizes of cached rendered pages in order of use, so
+// the least recently used can be evicted to keep the cache under a total
+// size. The cache itself still handles expiry.
+type cacheSizes struct {
+	mu      sync.Mutex
+	max     int
+	total   int
+	order   *list.List // of *sizedKey, most recently used first
+	entries map[string]*list.Element
+}
+
+type sizedKey struct {
+	key  string
+	size int
+}
+
+func newCacheSizes(max int) *cacheSizes {
+	return &cacheSizes{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// store records an entry as the most recently used while set puts it in
+// the cache, so that a flush can't come in between, giving the least
+// recently used keys that must be evicted to stay within the maximum. An
+// entry without a size isn't tracked.
+func (c *cacheSizes) store(key string, size int, set func()) (evict []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	set()
+	c.removeLocked(key)
+	if size == 0 {
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&sizedKey{key, size})
+	c.total += size
+	for c.total > c.max && c.order.Len() > 1 {
+		oldest := c.order.Back().Value.(*sizedKey)
+		c.removeLocked(oldest.key)
+		evict = append(evict, oldest.key)
+	}
+	return evict
+}
+
+// touch marks an entry as the most recently used.
+func (c *cacheSizes) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+	}
+}
+
+// remove forgets an entry that left the cache.
+func (c *cacheSizes) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *cacheSizes) removeLocked(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.total -= e.Value.(*sizedKey).size
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// flush forgets every entry while empty flushes the cache, so that an
+// entry stored meanwhile is either flushed and forgotten or kept and
+// tracked.
+func (c *cacheSizes) flush(empty func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	empty()
+	c.total = 0
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
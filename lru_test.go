@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCacheSizes(t *testing.T) {
+	type op struct {
+		key   string
+		size  int // stored if positive, touched if zero
+		evict []string
+	}
+	tests := []struct {
+		name  string
+		ops   []op
+		total int
+	}{
+		{
+			name:  "under the maximum",
+			ops:   []op{{"a", 30, nil}, {"b", 30, nil}, {"c", 40, nil}},
+			total: 100,
+		},
+		{
+			name:  "oldest evicted",
+			ops:   []op{{"a", 40, nil}, {"b", 40, nil}, {"c", 40, []string{"a"}}},
+			total: 80,
+		},
+		{
+			name:  "touched kept",
+			ops:   []op{{"a", 40, nil}, {"b", 40, nil}, {"a", 0, nil}, {"c", 40, []string{"b"}}},
+			total: 80,
+		},
+		{
+			name:  "several evicted",
+			ops:   []op{{"a", 30, nil}, {"b", 30, nil}, {"c", 30, nil}, {"d", 90, []string{"a", "b", "c"}}},
+			total: 90,
+		},
+		{
+			name:  "stored again",
+			ops:   []op{{"a", 60, nil}, {"a", 70, nil}, {"b", 30, nil}},
+			total: 100,
+		},
+		{
+			name:  "larger than the maximum",
+			ops:   []op{{"a", 30, nil}, {"b", 150, []string{"a"}}},
+			total: 150,
+		},
+	}
+	for _, tt := range tests {
+		c := newCacheSizes(100)
+		for _, o := range tt.ops {
+			if o.size == 0 {
+				c.touch(o.key)
+				continue
+			}
+			if evict := c.store(o.key, o.size, func() {}); !reflect.DeepEqual(evict, o.evict) {
+				t.Errorf("%s: storing %s evicted %v, want %v", tt.name, o.key, evict, o.evict)
+			}
+		}
+		if c.total != tt.total {
+			t.Errorf("%s: total %d, want %d", tt.name, c.total, tt.total)
+		}
+	}
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("page%d.md", i)] = fmt.Sprintf("%0500d", i)
+	}
+	s := newTestServer(t, "ttl: 5\ncache_max_bytes: 3000\n", files)
+	for i := 0; i < 10; i++ {
+		get(s, fmt.Sprintf("/page%d", i))
+		if s.cacheSizes.total > 3000 {
+			t.Fatalf("after page%d, cached %d bytes", i, s.cacheSizes.total)
+		}
+	}
+	if _, ok := s.cache.Get("/page0"); ok {
+		t.Error("the oldest page is still cached")
+	}
+	if _, ok := s.cache.Get("/page9"); !ok {
+		t.Error("the newest page isn't cached")
+	}
+	if n := s.cache.ItemCount(); n != len(s.cacheSizes.entries) {
+		t.Errorf("%d pages cached, %d tracked", n, len(s.cacheSizes.entries))
+	}
+}
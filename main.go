@@ -18,29 +18,259 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	fp "path/filepath"
+	"regexp"
+	"strings"
 
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	VERSION = "1.0.2"
 	USAGE   = `Usage of servemd:
-  servemd [--version | SETTINGS]
+  servemd [--version | --hash | --check SETTINGS | --print-config SETTINGS | --export DIR SETTINGS | [--stats] SETTINGS]
 
-  SETTINGS  	settings yaml file
+  SETTINGS  	settings yaml file, or - (or --stdin) to read it from stdin
   --version  	show version
+  --hash  	read a password from stdin and print its bcrypt hash
+  --check  	check the settings for problems and exit
+  --print-config	print the effective settings, with secrets redacted
+  --export DIR	write the rendered site into DIR as static files
+  --stats  	report content found in the served directory at startup
+  --stdin  	read the settings from stdin, in place of SETTINGS
 
   See https://github.com/lorepozo/servemd for documentation.
 `
 )
 
-var versionFlag = flag.Bool("version", false, "show version")
+// statsLimit bounds the number of files walked by --stats.
+const statsLimit = 100000
+
+var (
+	versionFlag = flag.Bool("version", false, "show version")
+	hashFlag    = flag.Bool("hash", false, "print bcrypt hash of password from stdin")
+	statsFlag   = flag.Bool("stats", false, "report served content at startup")
+	printFlag   = flag.Bool("print-config", false, "print effective settings")
+	checkFlag   = flag.Bool("check", false, "check settings and exit")
+	exportFlag  = flag.String("export", "", "write the rendered site to a directory")
+	stdinFlag   = flag.Bool("stdin", false, "read settings from stdin")
+)
+
+// reportContent logs counts of the pages, static files, and redirects
+// under dir, along with the number of secured routes. At most statsLimit
+// files are counted.
+func reportContent(dir string, secured int) {
+	var pages, static, redirects, seen int
+	errLimit := errors.New("limit reached")
+	err := fp.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		seen++
+		if seen > statsLimit {
+			return errLimit
+		}
+		switch fp.Ext(p) {
+		case ".md", ".pug", ".jade":
+			pages++
+		case ".redirect":
+			redirects++
+		default:
+			static++
+		}
+		return nil
+	})
+	more := ""
+	if err == errLimit {
+		more = fmt.Sprintf(" (stopped after %d files)", statsLimit)
+	}
+	log.Printf("serving %s: %d pages, %d static files, %d redirects, %d secured routes%s",
+		dir, pages, static, redirects, secured, more)
+}
+
+// resolvePath normalizes a path from the settings file for the host OS,
+// so forward slashes work on Windows, and makes it relative to base unless
+// it is already absolute.
+func resolvePath(base, p string) string {
+	p = fp.FromSlash(p)
+	if !fp.IsAbs(p) {
+		p = fp.Join(base, p)
+	}
+	return fp.Clean(p)
+}
+
+// envPattern matches an environment variable reference in settings, or
+// the $$ that escapes a literal $.
+var envPattern = regexp.MustCompile(`^(?:\$\$|\$\{(\w+)\})`)
+
+// envEscapes escape a variable's value for the quoted scalar it's in.
+var envEscapes = map[byte]*strings.Replacer{
+	'"':  strings.NewReplacer(`\`, `\\`, `"`, `\"`),
+	'\'': strings.NewReplacer(`'`, `''`),
+}
+
+// expandEnv replaces ${VAR} in a settings document with the environment
+// variable, which must be set, and $$ with $. It works on the text, before
+// it's parsed, so that values keep the type they're written as; comments
+// are left alone. In quoted scalars the value is escaped, and elsewhere it
+// is put as it is.
+func expandEnv(stu []byte) ([]byte, error) {
+	if !bytes.Contains(stu, []byte("$")) {
+		return stu, nil
+	}
+	var unset []string
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(string(stu), "\n") {
+		var quote byte
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case quote == 0 && c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+				out.WriteString(line[i:])
+				i = len(line)
+				continue
+			case quote == 0 && (c == '"' || c == '\'') && scalarStart(line[:i]):
+				quote = c
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				out.WriteString(line[i : i+2])
+				i++
+				continue
+			case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+				out.WriteString("''")
+				i++
+				continue
+			case quote != 0 && c == quote:
+				quote = 0
+			case c == '$':
+				m := envPattern.FindStringSubmatch(line[i:])
+				if m == nil {
+					break
+				}
+				i += len(m[0]) - 1
+				if m[0] == "$$" {
+					out.WriteByte('$')
+					continue
+				}
+				value, ok := os.LookupEnv(m[1])
+				if !ok {
+					unset = append(unset, m[1])
+				}
+				if r, ok := envEscapes[quote]; ok {
+					value = r.Replace(value)
+				}
+				out.WriteString(value)
+				continue
+			}
+			out.WriteByte(c)
+		}
+	}
+	if len(unset) > 0 {
+		return nil, fmt.Errorf("environment variable %s isn't set", strings.Join(unset, ", "))
+	}
+	return []byte(out.String()), nil
+}
+
+// scalarStart reports whether a quote after the text of a line so far
+// opens a quoted scalar, rather than being part of a plain one, like the
+// apostrophe in "title: Lore's docs".
+func scalarStart(before string) bool {
+	trimmed := strings.TrimRight(before, " \t")
+	if trimmed == "" {
+		return true
+	}
+	last := trimmed[len(trimmed)-1:]
+	// indicators like ": " and "- " need the space after them
+	spaced := len(trimmed) < len(before)
+	return strings.ContainsAny(last, "[{,") || spaced && strings.ContainsAny(last, ":-?")
+}
+
+// stdinSettings is the settings file name for reading settings from stdin.
+const stdinSettings = "-"
+
+// readSettings parses a settings file, resolving the paths in it relative
+// to the file. Settings read from stdin have their paths resolved relative
+// to the working directory.
+func readSettings(set string) (settings, error) {
+	st := settings{}
+	var stu []byte
+	var err error
+	if set == stdinSettings {
+		stu, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		stu, err = ioutil.ReadFile(set)
+	}
+	if err != nil {
+		return st, fmt.Errorf("couldn't open settings file %s: %v", set, err)
+	}
+	if stu, err = expandEnv(stu); err != nil {
+		return st, fmt.Errorf("couldn't expand settings file %s: %v", set, err)
+	}
+	if err := yaml.Unmarshal(stu, &st); err != nil {
+		return st, errors.New("couldn't parse settings file")
+	}
+	var stpath string
+	if set == stdinSettings {
+		stpath, _ = os.Getwd()
+	} else {
+		stpath, _ = fp.Abs(fp.Dir(set))
+	}
+	st.Dir = resolvePath(stpath, st.Dir)
+	if st.Template != "" {
+		st.Template = resolvePath(stpath, st.Template)
+	}
+	for name, tpl := range st.Templates {
+		st.Templates[name] = resolvePath(stpath, tpl)
+	}
+	for route, wk := range st.WellKnown {
+		if wk.File != "" {
+			wk.File = resolvePath(stpath, wk.File)
+		}
+		if wk.Dir != "" {
+			wk.Dir = resolvePath(stpath, wk.Dir)
+		}
+		st.WellKnown[route] = wk
+	}
+	for prefix, dir := range st.Mounts {
+		st.Mounts[prefix] = resolvePath(stpath, dir)
+	}
+	for i, v := range st.Vhosts {
+		if v.Dir != "" {
+			st.Vhosts[i].Dir = resolvePath(stpath, v.Dir)
+		}
+		if v.Template != "" {
+			st.Vhosts[i].Template = resolvePath(stpath, v.Template)
+		}
+	}
+	if strings.HasPrefix(st.Listen, "unix:") && st.Listen != "unix:" {
+		st.Listen = "unix:" + resolvePath(stpath, strings.TrimPrefix(st.Listen, "unix:"))
+	}
+	if st.Log != "" {
+		st.Log = resolvePath(stpath, st.Log)
+	}
+	if st.TLS.Cert != "" {
+		st.TLS.Cert = resolvePath(stpath, st.TLS.Cert)
+	}
+	if st.TLS.Privkey != "" {
+		st.TLS.Privkey = resolvePath(stpath, st.TLS.Privkey)
+	}
+	if st.TLS.ACME.Enabled {
+		if st.TLS.ACME.CacheDir == "" {
+			st.TLS.ACME.CacheDir = "acme-cache"
+		}
+		st.TLS.ACME.CacheDir = resolvePath(stpath, st.TLS.ACME.CacheDir)
+	}
+	return st, nil
+}
 
 func main() {
 	flag.Usage = func() {
@@ -51,48 +281,91 @@ func main() {
 		fmt.Fprintln(os.Stderr, VERSION)
 		os.Exit(0)
 	}
-	if flag.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "no settings file supplied")
-		os.Exit(1)
+	if *hashFlag {
+		pw, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, "couldn't read password")
+			os.Exit(1)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(pw, "\r\n")), bcrypt.DefaultCost)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't hash password: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(hash))
+		os.Exit(0)
 	}
-	set := flag.Arg(0)
-	stu, err := ioutil.ReadFile(set)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "couldn't open settings file")
-		os.Exit(1)
+	set := stdinSettings
+	if !*stdinFlag {
+		if flag.NArg() < 1 {
+			fmt.Fprintln(os.Stderr, "no settings file supplied")
+			os.Exit(1)
+		}
+		set = flag.Arg(0)
 	}
-	st := settings{}
-	err = yaml.Unmarshal(stu, &st)
+	st, err := readSettings(set)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "couldn't parse settings file")
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	stpath, _ := fp.Abs(fp.Dir(set))
-	if !fp.IsAbs(st.Dir) {
-		st.Dir = fp.Join(stpath, st.Dir)
+	if errs := st.validate(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
 	}
-	if !fp.IsAbs(st.Template) {
-		st.Template = fp.Join(stpath, st.Template)
+	warnings := st.warnings()
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
 	}
-	if st.Log != "" && !fp.IsAbs(st.Log) {
-		st.Log = fp.Join(stpath, st.Log)
+	if *checkFlag {
+		if len(warnings) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
-	if st.TLS.Cert != "" && !fp.IsAbs(st.TLS.Cert) {
-		st.TLS.Cert = fp.Join(stpath, st.TLS.Cert)
+
+	if *printFlag {
+		st.applyDefaults()
+		out, err := yaml.Marshal(st.redacted())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't print settings: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		os.Exit(0)
 	}
-	if st.TLS.Privkey != "" && !fp.IsAbs(st.TLS.Privkey) {
-		st.TLS.Privkey = fp.Join(stpath, st.TLS.Privkey)
+
+	if *exportFlag != "" {
+		s := st.toServer()
+		if err := s.export(*exportFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't export site: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
-	logFile := os.Stderr
+	st.applyDefaults()
+	var logFile io.Writer = os.Stderr
 	if st.Log != "" {
-		f, err := os.OpenFile(st.Log, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		f, err := openRotatingFile(st.Log, int64(st.LogMaxSizeMB)<<20, st.LogMaxBackups)
 		if err == nil {
 			defer f.Close()
+			f.watchRotate()
 			logFile = f
+		} else {
+			fmt.Fprintf(os.Stderr, "couldn't open log file %s: %v\n", st.Log, err)
 		}
 	}
 	log.SetOutput(logFile)
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	st.toServer().serve()
+	if *statsFlag {
+		reportContent(st.Dir, len(st.Secrets))
+	}
+	s := st.toServer()
+	if set != stdinSettings {
+		// settings from stdin can't be read again
+		s.settingsFile = set
+	}
+	s.serve()
 }
@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("SERVEMD_TEST_HOST", "docs.example.com")
+	t.Setenv("SERVEMD_TEST_EMPTY", "")
+	tests := []struct {
+		yml   string
+		want  map[string]interface{}
+		unset string
+	}{
+		{"host: ${SERVEMD_TEST_HOST}\n", map[string]interface{}{"host": "docs.example.com"}, ""},
+		{"host: www.${SERVEMD_TEST_HOST}:8080\n", map[string]interface{}{"host": "www.docs.example.com:8080"}, ""},
+		{"host: a${SERVEMD_TEST_EMPTY}b\n", map[string]interface{}{"host": "ab"}, ""},
+		{"secrets:\n  private:\n    alice: ${SERVEMD_TEST_HOST}\n", map[string]interface{}{"secrets": map[interface{}]interface{}{"private": map[interface{}]interface{}{"alice": "docs.example.com"}}}, ""},
+		// $$ escapes a literal $, and anything else is left alone
+		{"host: pa$$${SERVEMD_TEST_HOST}\n", map[string]interface{}{"host": "pa$docs.example.com"}, ""},
+		{"host: pa$$word\n", map[string]interface{}{"host": "pa$word"}, ""},
+		{"host: $$${SERVEMD_TEST_UNSET}\n", nil, "SERVEMD_TEST_UNSET"},
+		{"host: $SERVEMD_TEST_HOST\n", map[string]interface{}{"host": "$SERVEMD_TEST_HOST"}, ""},
+		{"host: ${SERVEMD_TEST_UNSET}\nlog: ${SERVEMD_TEST_OTHER}\n", nil, "SERVEMD_TEST_UNSET, SERVEMD_TEST_OTHER"},
+	}
+	for _, tt := range tests {
+		out, err := expandEnv([]byte(tt.yml))
+		if tt.unset != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.unset) {
+				t.Errorf("%q: got error %v, want one naming %s", tt.yml, err, tt.unset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.yml, err)
+			continue
+		}
+		var got map[string]interface{}
+		if err := yaml.Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.yml, got, tt.want)
+		}
+	}
+}
+
+func TestEnvSecrets(t *testing.T) {
+	t.Setenv("SERVEMD_TEST_PW", "env_pw")
+	s := newTestServer(t, "secrets:\n  private: ${SERVEMD_TEST_PW}\n", map[string]string{
+		"private/page.md": "secret",
+	})
+	if got := authGet(s, "/private/page", "any", "env_pw").StatusCode(); got != 200 {
+		t.Errorf("password from the environment: got %d, want 200", got)
+	}
+	if got := authGet(s, "/private/page", "any", "${SERVEMD_TEST_PW}").StatusCode(); got != 401 {
+		t.Errorf("unexpanded password: got %d, want 401", got)
+	}
+}
+
+func TestStdinSettings(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"site/page.md": "page", "tpl.html": "{{ .Content }}"})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	go func() {
+		w.Write([]byte("dir: site\ntemplate: tpl.html\n"))
+		w.Close()
+	}()
+
+	st, err := readSettings(stdinSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// relative to the working directory
+	realDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fp.Join(realDir, "site"); st.Dir != want {
+		t.Errorf("got dir %s, want %s", st.Dir, want)
+	}
+	if want := fp.Join(realDir, "tpl.html"); st.Template != want {
+		t.Errorf("got template %s, want %s", st.Template, want)
+	}
+	s := st.toServer()
+	if s.path != st.Dir {
+		t.Errorf("serving %s, want %s", s.path, st.Dir)
+	}
+	if body := string(get(s, "/page").Body()); !strings.Contains(body, "<p>page</p>") {
+		t.Errorf("GET /page: got %q", body)
+	}
+}
+
+func TestExpandEnvKeepsTypes(t *testing.T) {
+	t.Setenv("SERVEMD_TEST_HOST", "docs.example.com")
+	t.Setenv("SERVEMD_TEST_TTL", "15")
+	t.Setenv("SERVEMD_TEST_QUOTE", `a"b'c\d`)
+	dir := t.TempDir()
+	set := fp.Join(dir, "settings.yaml")
+	yml := `# the host comes from ${SERVEMD_TEST_UNSET}
+host: ${SERVEMD_TEST_HOST}  # and so does ${SERVEMD_TEST_UNSET}
+listen: unix:site.sock
+listen_mode: 0640
+ttl: ${SERVEMD_TEST_TTL}
+secrets:
+  plain: no
+  double: "p$$w${SERVEMD_TEST_QUOTE}"
+  single: 'it''s ${SERVEMD_TEST_QUOTE}'
+  apostrophe: Lore's ${SERVEMD_TEST_HOST}
+`
+	if err := ioutil.WriteFile(set, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := readSettings(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Host != "docs.example.com" || st.ListenMode != "0640" || st.TTL != 15 {
+		t.Errorf("got host %q, listen_mode %q, ttl %d", st.Host, st.ListenMode, st.TTL)
+	}
+	secrets := map[string]string{
+		"plain":      "no",
+		"double":     `p$w` + `a"b'c\d`,
+		"single":     `it's ` + `a"b'c\d`,
+		"apostrophe": "Lore's docs.example.com",
+	}
+	for route, want := range secrets {
+		if got, _ := st.Secrets[route].passwordFor("any"); got != want {
+			t.Errorf("secret %s: got %q, want %q", route, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	fp "path/filepath"
+	"testing"
+)
+
+func TestResolvePathWindows(t *testing.T) {
+	tests := []struct {
+		base, p, want string
+	}{
+		{`C:\sites`, "content", `C:\sites\content`},
+		{`C:\sites`, "docs/templates/page.html", `C:\sites\docs\templates\page.html`},
+		{`C:\sites`, `docs/templates\page.html`, `C:\sites\docs\templates\page.html`},
+		{`C:\sites`, "./content/../shared", `C:\sites\shared`},
+		{`C:\sites`, "../shared/page.html", `C:\shared\page.html`},
+		{`C:\sites`, "D:/content", `D:\content`},
+		{`C:\sites`, `D:\content`, `D:\content`},
+	}
+	for _, tt := range tests {
+		if got := resolvePath(tt.base, tt.p); got != tt.want {
+			t.Errorf("resolvePath(%q, %q) = %q, want %q", tt.base, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestReadSettingsWindows(t *testing.T) {
+	dir := t.TempDir()
+	set := fp.Join(dir, "settings.yaml")
+	yml := "dir: site/docs\ntemplate: templates/page.html\nlog: logs\\server.log\n"
+	if err := ioutil.WriteFile(set, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := readSettings(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ field, got, want string }{
+		{"dir", st.Dir, fp.Join(dir, "site", "docs")},
+		{"template", st.Template, fp.Join(dir, "templates", "page.html")},
+		{"log", st.Log, fp.Join(dir, "logs", "server.log")},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s resolved to %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+}
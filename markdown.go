@@ -0,0 +1,277 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/russross/blackfriday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"gopkg.in/yaml.v2"
+)
+
+// These match the flags used by blackfriday.MarkdownCommon.
+const (
+	commonHTMLFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_DASHES |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+
+	commonExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS |
+		blackfriday.EXTENSION_HEADER_IDS |
+		blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+		blackfriday.EXTENSION_DEFINITION_LISTS
+)
+
+// Handling of raw HTML embedded in markdown.
+const (
+	rawHTMLAllow = iota
+	rawHTMLStrip
+	rawHTMLEscape
+)
+
+// escapeHTMLRenderer renders raw HTML in markdown as escaped text.
+type escapeHTMLRenderer struct {
+	blackfriday.Renderer
+}
+
+func (r escapeHTMLRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+	out.WriteString("<p>")
+	out.WriteString(html.EscapeString(string(bytes.TrimSpace(text))))
+	out.WriteString("</p>\n")
+}
+
+func (r escapeHTMLRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	out.WriteString(html.EscapeString(string(tag)))
+}
+
+// markdownEngine renders markdown source to HTML.
+type markdownEngine interface {
+	Render(src []byte) []byte
+
+	// Extend gives an engine that also enables the named extensions.
+	Extend(names []string) (markdownEngine, error)
+}
+
+// blackfridayExtensions maps extension names to blackfriday flags.
+var blackfridayExtensions = map[string]int{
+	"tables":           blackfriday.EXTENSION_TABLES,
+	"fenced_code":      blackfriday.EXTENSION_FENCED_CODE,
+	"autolink":         blackfriday.EXTENSION_AUTOLINK,
+	"strikethrough":    blackfriday.EXTENSION_STRIKETHROUGH,
+	"hard_wrap":        blackfriday.EXTENSION_HARD_LINE_BREAK,
+	"footnotes":        blackfriday.EXTENSION_FOOTNOTES,
+	"definition_lists": blackfriday.EXTENSION_DEFINITION_LISTS,
+	"header_ids":       blackfriday.EXTENSION_HEADER_IDS,
+}
+
+// blackfridayEngine renders with blackfriday v1, by default with the
+// extensions of blackfriday.MarkdownCommon.
+type blackfridayEngine struct {
+	rawHTML    int
+	extensions int
+}
+
+func (e blackfridayEngine) Render(src []byte) []byte {
+	flags := commonHTMLFlags
+	if e.rawHTML == rawHTMLStrip {
+		flags |= blackfriday.HTML_SKIP_HTML
+	}
+	renderer := blackfriday.HtmlRenderer(flags, "", "")
+	if e.rawHTML == rawHTMLEscape {
+		renderer = escapeHTMLRenderer{renderer}
+	}
+	return blackfriday.Markdown(src, renderer, e.extensions)
+}
+
+func (e blackfridayEngine) Extend(names []string) (markdownEngine, error) {
+	for _, name := range names {
+		ext, ok := blackfridayExtensions[name]
+		if !ok {
+			return e, fmt.Errorf("unknown markdown extension '%s'", name)
+		}
+		e.extensions |= ext
+	}
+	return e, nil
+}
+
+// goldmarkExtensions maps extension names to goldmark extensions. Fenced
+// code is part of CommonMark, so it needs no extension.
+var goldmarkExtensions = map[string]goldmark.Extender{
+	"tables":           extension.Table,
+	"fenced_code":      nil,
+	"autolink":         extension.Linkify,
+	"strikethrough":    extension.Strikethrough,
+	"hard_wrap":        nil,
+	"footnotes":        extension.Footnote,
+	"definition_lists": extension.DefinitionList,
+	"header_ids":       nil,
+}
+
+// goldmarkEngine renders with goldmark, either strictly per CommonMark or
+// with the named extensions, which also get GitHub Flavored Markdown task
+// lists. Raw HTML can't be escaped, only allowed or stripped.
+type goldmarkEngine struct {
+	rawHTML    int
+	commonMark bool
+	extensions []string
+	md         goldmark.Markdown
+}
+
+func newGoldmarkEngine(rawHTML int, commonMark bool, extensions ...string) goldmarkEngine {
+	e := goldmarkEngine{rawHTML: rawHTML, commonMark: commonMark, extensions: extensions}
+	var exts []goldmark.Extender
+	if !commonMark {
+		exts = append(exts, extension.TaskList)
+	}
+	var ropts []renderer.Option
+	var popts []parser.Option
+	for _, name := range extensions {
+		switch name {
+		case "hard_wrap":
+			ropts = append(ropts, gmhtml.WithHardWraps())
+		case "header_ids":
+			popts = append(popts, parser.WithHeadingAttribute())
+		default:
+			if ext := goldmarkExtensions[name]; ext != nil {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	if rawHTML == rawHTMLAllow {
+		ropts = append(ropts, gmhtml.WithUnsafe())
+	}
+	e.md = goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithRendererOptions(ropts...),
+		goldmark.WithParserOptions(popts...),
+	)
+	return e
+}
+
+func (e goldmarkEngine) Render(src []byte) []byte {
+	buf := new(bytes.Buffer)
+	if err := e.md.Convert(src, buf); err != nil {
+		log.Printf("goldmark couldn't render markdown: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func (e goldmarkEngine) Extend(names []string) (markdownEngine, error) {
+	for _, name := range names {
+		if _, ok := goldmarkExtensions[name]; !ok {
+			return e, fmt.Errorf("unknown markdown extension '%s'", name)
+		}
+	}
+	extensions := append(append([]string{}, e.extensions...), names...)
+	return newGoldmarkEngine(e.rawHTML, e.commonMark, extensions...), nil
+}
+
+// splitFrontMatter separates a leading YAML front matter block, delimited
+// by "---" lines, from markdown source. If there is none, or it can't be
+// parsed, the metadata is nil and the source is returned unchanged.
+func splitFrontMatter(filename string, md []byte) (map[string]interface{}, []byte) {
+	src := bytes.Replace(md, []byte("\r\n"), []byte("\n"), -1)
+	if !bytes.HasPrefix(src, []byte("---\n")) {
+		return nil, md
+	}
+	end := bytes.Index(src[4:], []byte("\n---\n"))
+	if end < 0 {
+		if !bytes.HasSuffix(src, []byte("\n---")) {
+			return nil, md
+		}
+		end = len(src) - 4 - len("\n---")
+	}
+	head := src[4 : 4+end]
+	body := []byte{}
+	if rest := 4 + end + len("\n---\n"); rest < len(src) {
+		body = src[rest:]
+	}
+	meta := make(map[string]interface{})
+	if err := yaml.Unmarshal(head, &meta); err != nil {
+		log.Printf("couldn't parse front matter of %s: %v", filename, err)
+		return nil, md
+	}
+	return meta, body
+}
+
+// markdownFor gives the engine for a markdown file, honoring "engine" and
+// "extensions" overrides in its front matter. Invalid overrides are logged
+// and ignored.
+func (s *server) markdownFor(filename string, meta map[string]interface{}) markdownEngine {
+	engine := s.markdown
+	if name, ok := meta["engine"]; ok {
+		if e, ok := s.engines[fmt.Sprint(name)]; ok {
+			engine = e
+		} else {
+			log.Printf("bad engine '%v' in front matter of %s, using default", name, filename)
+		}
+	}
+	if list, ok := meta["extensions"]; ok {
+		var names []string
+		items, ok := list.([]interface{})
+		for _, item := range items {
+			names = append(names, fmt.Sprint(item))
+		}
+		if !ok {
+			log.Printf("bad extensions in front matter of %s, should be a list", filename)
+		} else if e, err := engine.Extend(names); err != nil {
+			log.Printf("bad extensions in front matter of %s: %v", filename, err)
+		} else {
+			engine = e
+		}
+	}
+	return engine
+}
+
+// markdownTitle gives the title of a rendered markdown file, from its
+// front matter, its first <h1>, or else its name without the extension.
+func markdownTitle(filename string, meta map[string]interface{}, out []byte) string {
+	if title, ok := meta["title"]; ok && title != nil {
+		return fmt.Sprint(title)
+	}
+	for _, m := range headingPattern.FindAllSubmatch(out, -1) {
+		if string(m[1]) == "1" {
+			return headingText(string(m[3]))
+		}
+	}
+	name := fp.Base(filename)
+	return strings.TrimSuffix(name, fp.Ext(name))
+}
+
+// renderMarkdown renders markdown source to HTML, also giving its front
+// matter, if any.
+func (s *server) renderMarkdown(filename string, md []byte) ([]byte, map[string]interface{}) {
+	meta, body := splitFrontMatter(filename, md)
+	return s.markdownFor(filename, meta).Render(body), meta
+}
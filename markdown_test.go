@@ -0,0 +1,177 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		meta map[string]interface{}
+		body string
+	}{
+		{
+			name: "title and description",
+			md:   "---\ntitle: Hello\ndescription: A page\n---\n# Body\n",
+			meta: map[string]interface{}{"title": "Hello", "description": "A page"},
+			body: "# Body\n",
+		},
+		{
+			name: "CRLF line endings",
+			md:   "---\r\ntitle: Hello\r\n---\r\nbody\r\n",
+			meta: map[string]interface{}{"title": "Hello"},
+			body: "body\n",
+		},
+		{
+			name: "only front matter",
+			md:   "---\ntitle: Hello\n---",
+			meta: map[string]interface{}{"title": "Hello"},
+			body: "",
+		},
+		{
+			name: "no front matter",
+			md:   "# Body\n\n---\n\ntext\n",
+			body: "# Body\n\n---\n\ntext\n",
+		},
+		{
+			name: "unclosed",
+			md:   "---\ntitle: Hello\nbody\n",
+			body: "---\ntitle: Hello\nbody\n",
+		},
+		{
+			name: "invalid YAML",
+			md:   "---\ntitle: [unclosed\n---\nbody\n",
+			body: "---\ntitle: [unclosed\n---\nbody\n",
+		},
+	}
+	for _, tt := range tests {
+		meta, body := splitFrontMatter("page.md", []byte(tt.md))
+		if len(meta) != len(tt.meta) || len(meta) > 0 && !reflect.DeepEqual(meta, tt.meta) {
+			t.Errorf("%s: got meta %v, want %v", tt.name, meta, tt.meta)
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+func TestFrontMatterInTemplate(t *testing.T) {
+	s := newTestServer(t, "template: tpl.html\n", map[string]string{
+		"tpl.html": "[{{ .Meta.title }}|{{ .Meta.description }}] {{ .Content }}",
+		"with.md":  "---\ntitle: Hello\ndescription: A page\n---\ntext\n",
+		"plain.md": "text\n",
+	})
+	tests := []struct {
+		path, want string
+	}{
+		{"/with", "[Hello|A page] <p>text</p>"},
+		{"/plain", "[<no value>|<no value>] <p>text</p>"},
+	}
+	for _, tt := range tests {
+		if body := string(get(s, tt.path).Body()); strings.TrimSpace(body) != tt.want {
+			t.Errorf("GET %s: got %q, want %q", tt.path, body, tt.want)
+		}
+	}
+}
+
+func TestTitle(t *testing.T) {
+	s := newTestServer(t, "", map[string]string{
+		"both.md":    "---\ntitle: From Front Matter\n---\n# From Heading\n",
+		"heading.md": "## Second\n\n# From *Heading*\n\n# Another\n",
+		"name.md":    "## Not a title\n\ntext\n",
+		"empty.md":   "---\ntitle:\n---\n# From Heading\n",
+	})
+	tests := []struct {
+		path, want string
+	}{
+		{"/both", "From Front Matter"},
+		{"/heading", "From Heading"},
+		{"/name", "name"},
+		{"/empty", "From Heading"},
+	}
+	for _, tt := range tests {
+		body := string(get(s, tt.path).Body())
+		if want := "<title>" + tt.want + "</title>"; !strings.Contains(body, want) {
+			t.Errorf("GET %s: got %q, want it to contain %q", tt.path, body, want)
+		}
+	}
+}
+
+func TestMarkdownExtensions(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string // in the output only with the extension on
+	}{
+		{"tables", "| a | b |\n|---|---|\n| 1 | 2 |\n", "<table>"},
+		{"fenced_code", "```\ncode\n```\n", "<pre><code>"},
+		{"autolink", "see https://example.com now\n", `<a href="https://example.com"`},
+		{"strikethrough", "~~gone~~\n", "<del>"},
+		{"hard_wrap", "one\ntwo\n", "<br"},
+		{"footnotes", "text[^1]\n\n[^1]: a note\n", "footnote-ref"},
+		{"definition_lists", "Term\n: definition\n", "<dl>"},
+		{"header_ids", "# Title {#custom}\n", `id="custom"`},
+	}
+	for _, engine := range []string{"blackfriday", "goldmark"} {
+		for _, tt := range tests {
+			for _, on := range []bool{true, false} {
+				if engine == "goldmark" && tt.name == "fenced_code" && !on {
+					// part of CommonMark, so always on
+					continue
+				}
+				yml := fmt.Sprintf("markdown:\n  engine: %s\n  %s: %v\n", engine, tt.name, on)
+				s := newTestServer(t, yml, nil)
+				out := string(s.markdown.Render([]byte(tt.md)))
+				if strings.Contains(out, tt.want) != on {
+					t.Errorf("%s with %s: %v: got %q", engine, tt.name, on, out)
+				}
+			}
+		}
+	}
+}
+
+func TestMarkdownExtensionDefaults(t *testing.T) {
+	// left out, extensions are those of blackfriday.MarkdownCommon
+	tests := []struct {
+		md   string
+		want string
+		on   bool
+	}{
+		{"| a | b |\n|---|---|\n| 1 | 2 |\n", "<table>", true},
+		{"~~gone~~\n", "<del>", true},
+		{"see https://example.com now\n", `<a href="https://example.com"`, true},
+		{"# Title {#custom}\n", `id="custom"`, true},
+		{"one\ntwo\n", "<br", false},
+		{"text[^1]\n\n[^1]: a note\n", "footnote-ref", false},
+	}
+	for _, engine := range []string{"blackfriday", "goldmark"} {
+		s := newTestServer(t, "markdown:\n  engine: "+engine+"\n", nil)
+		for _, tt := range tests {
+			out := string(s.markdown.Render([]byte(tt.md)))
+			if strings.Contains(out, tt.want) != tt.on {
+				t.Errorf("%s: %q: got %q", engine, tt.md, out)
+			}
+		}
+	}
+}
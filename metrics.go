@@ -0,0 +1,156 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request
+// duration histogram.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics counts requests for Prometheus to scrape.
+type metrics struct {
+	mu           sync.Mutex
+	requests     map[int]uint64 // by status code
+	durations    []uint64       // by bucket, not cumulative
+	durationSum  float64
+	bytes        uint64
+	cacheHits    uint64
+	cacheMisses  uint64
+	renderErrors map[string]uint64 // by stage
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:     make(map[int]uint64),
+		durations:    make([]uint64, len(durationBuckets)),
+		renderErrors: make(map[string]uint64),
+	}
+}
+
+// observe counts an answered request. It does nothing if metrics are off,
+// as do the other methods.
+func (m *metrics) observe(status int, d time.Duration, size int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[status]++
+	seconds := d.Seconds()
+	m.durationSum += seconds
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			m.durations[i]++
+			break
+		}
+	}
+	m.bytes += uint64(size)
+}
+
+// cacheLookup counts a lookup in the response cache.
+func (m *metrics) cacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// renderError counts a file that failed to render.
+func (m *metrics) renderError(stage string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renderErrors[stage]++
+}
+
+// write gives the metrics in the Prometheus text format.
+func (m *metrics) write() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "# HELP servemd_requests_total Requests answered, by status code.")
+	fmt.Fprintln(buf, "# TYPE servemd_requests_total counter")
+	var codes []int
+	var count uint64
+	for code, n := range m.requests {
+		codes = append(codes, code)
+		count += n
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(buf, "servemd_requests_total{code=\"%d\"} %d\n", code, m.requests[code])
+	}
+	fmt.Fprintln(buf, "# HELP servemd_request_duration_seconds Time taken to answer requests.")
+	fmt.Fprintln(buf, "# TYPE servemd_request_duration_seconds histogram")
+	var cumulative uint64
+	for i, le := range durationBuckets {
+		cumulative += m.durations[i]
+		fmt.Fprintf(buf, "servemd_request_duration_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(buf, "servemd_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(buf, "servemd_request_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(buf, "servemd_request_duration_seconds_count %d\n", count)
+	fmt.Fprintln(buf, "# HELP servemd_response_bytes_total Bytes of response bodies served.")
+	fmt.Fprintln(buf, "# TYPE servemd_response_bytes_total counter")
+	fmt.Fprintf(buf, "servemd_response_bytes_total %d\n", m.bytes)
+	fmt.Fprintln(buf, "# HELP servemd_cache_lookups_total Lookups in the response cache, by result.")
+	fmt.Fprintln(buf, "# TYPE servemd_cache_lookups_total counter")
+	fmt.Fprintf(buf, "servemd_cache_lookups_total{result=\"hit\"} %d\n", m.cacheHits)
+	fmt.Fprintf(buf, "servemd_cache_lookups_total{result=\"miss\"} %d\n", m.cacheMisses)
+	fmt.Fprintln(buf, "# HELP servemd_render_errors_total Files that failed to render, by stage.")
+	fmt.Fprintln(buf, "# TYPE servemd_render_errors_total counter")
+	var stages []string
+	for stage := range m.renderErrors {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		fmt.Fprintf(buf, "servemd_render_errors_total{stage=%q} %d\n", stage, m.renderErrors[stage])
+	}
+	return buf.Bytes()
+}
+
+// serveMetrics answers requests for the metrics path, reporting whether
+// the request was one. The metrics are public, like well-known paths.
+func (s *server) serveMetrics(ctx *fasthttp.RequestCtx) bool {
+	if s.metricsPath == "" || string(ctx.Path()) != s.metricsPath {
+		return false
+	}
+	ctx.Response.Header.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetBody(s.metrics.write())
+	logRequest(ctx, fasthttp.StatusOK, "metrics")
+	return true
+}
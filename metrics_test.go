@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape gives the value of each sample on the metrics path, by name and
+// labels as written.
+func scrape(t *testing.T, s *server) map[string]float64 {
+	t.Helper()
+	resp := get(s, "/metrics")
+	if resp.StatusCode() != 200 {
+		t.Fatalf("scrape: got %d", resp.StatusCode())
+	}
+	samples := make(map[string]float64)
+	for _, line := range strings.Split(string(resp.Body()), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("scrape: bad sample %q", line)
+		}
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	// the metrics path is answered even where a secured route would be
+	s := newTestServer(t, "ttl: 5\nmetrics: /metrics\nsecrets:\n  metrics: pw\n", map[string]string{"page.md": "page", "other.md": "other"})
+	tests := []struct {
+		name   string
+		uri    string
+		sample string
+		delta  float64 // counting the scrape before, but not after
+	}{
+		{"not found", "/missing", `servemd_requests_total{code="404"}`, 1},
+		{"ok", "/page", `servemd_requests_total{code="200"}`, 2},
+		{"cache miss", "/other", `servemd_cache_lookups_total{result="miss"}`, 1},
+		{"cache hit", "/page", `servemd_cache_lookups_total{result="hit"}`, 1},
+		{"durations", "/page", `servemd_request_duration_seconds_count`, 2},
+	}
+	for _, tt := range tests {
+		before := scrape(t, s)
+		get(s, tt.uri)
+		after := scrape(t, s)
+		if d := after[tt.sample] - before[tt.sample]; d != tt.delta {
+			t.Errorf("%s: %s went from %g to %g, want +%g", tt.name, tt.sample, before[tt.sample], after[tt.sample], tt.delta)
+		}
+	}
+	before := scrape(t, s)
+	size := len(get(s, "/page").Body())
+	after := scrape(t, s)
+	if d := after["servemd_response_bytes_total"] - before["servemd_response_bytes_total"]; d < float64(size) {
+		t.Errorf("bytes went up %g for a %d byte page", d, size)
+	}
+}
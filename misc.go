@@ -19,28 +19,65 @@ package main
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/rand"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"mime"
+	"net"
+	"net/url"
 	"os"
 	"path"
+	fp "path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/patrickmn/go-cache"
 	"github.com/valyala/fasthttp"
 )
 
-const logf = "[%s %s] %d: %s"
+const logf = "[%s %s] %d: %s (%s)"
 
 const defaultTpl = `<!doctype html><html>
-<head><meta http-equiv="content-type" content="text/html; charset=utf-8"></head>
+<head><meta http-equiv="content-type" content="text/html; charset=utf-8"><title>{{ .Title | html }}</title>{{ if .Base }}<base href="{{ .Base }}">{{ end }}</head>
 <body>{{ .Content }}</body>
 </html>`
 
 type templateContent struct {
 	Content string
+
+	// Title is the page title as plain text: the front matter title, the
+	// first <h1>, or the file name.
+	Title string
+
+	// Base is the URL that relative links resolve against, set when a
+	// directory index is served without a trailing slash.
+	Base string
+
+	// Meta is the front matter of a markdown file, or nil if it has none.
+	Meta map[string]interface{}
+
+	// TOC is the table of contents of a markdown file as an HTML list, if
+	// one was built.
+	TOC string
+
+	// Figures is the list of figures and tables of a markdown file as an
+	// HTML list, if they were numbered.
+	Figures string
+
+	// Lang is the language of the file, from its name or else the default
+	// language.
+	Lang string
+
+	// Query holds the request's query arguments that the route varies on.
+	Query map[string]string
+
+	// LastCommit is the last commit of a markdown file, if configured.
+	LastCommit *commitInfo
 }
 
 // parseHeader parses comma-separated key=value pairs into a map.
@@ -56,28 +93,140 @@ func parseHeader(s string) map[string]string {
 	return result
 }
 
+// wellKnownMaxAge is the client cache lifetime in seconds for configured
+// well-known paths.
+const wellKnownMaxAge = 86400
+
+// cacheNoStore is the cache policy for routes that are never cached.
+const cacheNoStore = "nostore"
+
+// Handling of a dir that is a file.
+const (
+	dirFileFail  = "fail"
+	dirFileServe = "serve"
+)
+
+// Handling of path segments with trailing dots or spaces.
+const (
+	oddNamesReject   = "reject"
+	oddNamesRedirect = "redirect"
+)
+
 const (
 	requiredNone = iota
 	requiredSecrets
 	requiredAll
 )
 
-func handlerInternalError(err error) fasthttp.RequestHandler {
+// tlsRequired maps the values of 'tls.required' to the requests that must
+// use TLS.
+var tlsRequired = map[string]int{
+	"none":    requiredNone,
+	"secrets": requiredSecrets,
+	"all":     requiredAll,
+}
+
+// handlerInternalError responds with 500, logging the error. Clients only
+// see it if debug is set.
+func handlerInternalError(err error, debug bool) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		ctx.Response.SetStatusCode(fasthttp.StatusInternalServerError)
-		ctx.Response.SetBodyString(err.Error())
-		log.Printf(logf, ctx.Method(), ctx.Path(), fasthttp.StatusInternalServerError, err.Error())
+		if debug {
+			ctx.Response.SetBodyString(err.Error())
+		} else {
+			ctx.Response.SetBodyString("Internal Server Error")
+		}
+		logRequest(ctx, fasthttp.StatusInternalServerError, err.Error())
 	}
 }
 
 func handlerLiteralFile(pathStr string) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		mimeType := mime.TypeByExtension(path.Ext(pathStr))
-		if mimeType != "" {
-			ctx.Response.Header.Set("Content-Type", mimeType)
+		var size int64
+		if fi, err := os.Stat(pathStr); err == nil {
+			size = fi.Size()
+			etag := fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+			if checkETag(ctx, etag) {
+				return
+			}
 		}
+		// the content type comes from the file a symlink points to, so
+		// e.g. "latest" linking to "v2.3.pdf" is served as a PDF
+		target := pathStr
+		if resolved, err := fp.EvalSymlinks(pathStr); err == nil {
+			target = resolved
+		}
+		mimeType := mime.TypeByExtension(path.Ext(target))
+		status := ctx.Response.StatusCode()
+		// SendFile honors Range requests with 206 Partial Content (or 416
+		// if unsatisfiable) and advertises Accept-Ranges
 		ctx.SendFile(pathStr)
-		log.Printf(logf, ctx.Method(), ctx.Path(), fasthttp.StatusOK, "literal "+pathStr)
+		if ctx.Response.StatusCode() == fasthttp.StatusRequestedRangeNotSatisfiable {
+			// tell the client what range would have been satisfiable
+			ctx.Response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		}
+		if status != fasthttp.StatusOK && ctx.Response.StatusCode() == fasthttp.StatusOK {
+			// keep the status set by a wrapping handler
+			ctx.Response.SetStatusCode(status)
+		}
+		if mimeType != "" && ctx.Response.StatusCode() < 300 {
+			// SendFile sets the type from pathStr, so override it after
+			ctx.Response.Header.SetContentType(mimeType)
+		}
+		logRequest(ctx, ctx.Response.StatusCode(), "literal "+pathStr)
+	}
+}
+
+// checkETag sets the ETag header of a successful response. If the request
+// already has that version, it responds with 304 and reports true.
+func checkETag(ctx *fasthttp.RequestCtx, etag string) bool {
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		return false
+	}
+	ctx.Response.Header.Set("ETag", etag)
+	for _, match := range strings.Split(string(ctx.Request.Header.Peek("If-None-Match")), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			ctx.NotModified()
+			// NotModified resets the headers
+			ctx.Response.Header.Set("ETag", etag)
+			logRequest(ctx, fasthttp.StatusNotModified, "")
+			return true
+		}
+	}
+	return false
+}
+
+// handlerWeakETag wraps a handler so that compressed responses have a weak
+// ETag, since the handler gives the same one to every encoding of the
+// content. If-None-Match is compared weakly, so both still revalidate.
+func handlerWeakETag(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+		etag := ctx.Response.Header.Peek("ETag")
+		if len(etag) > 0 && !bytes.HasPrefix(etag, []byte("W/")) && len(ctx.Response.Header.ContentEncoding()) > 0 {
+			ctx.Response.Header.Set("ETag", "W/"+string(etag))
+		}
+	}
+}
+
+func handlerContent(ident, content string) fasthttp.RequestHandler {
+	mimeType := mime.TypeByExtension(path.Ext(ident))
+	if mimeType == "" {
+		mimeType = "text/plain; charset=utf-8"
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("Content-Type", mimeType)
+		ctx.Response.SetBodyString(content)
+		logRequest(ctx, fasthttp.StatusOK, "content "+ident)
+	}
+}
+
+func handlerMaxAge(seconds int, h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	cc := fmt.Sprintf("public, max-age=%d", seconds)
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("Cache-Control", cc)
+		h(ctx)
 	}
 }
 
@@ -85,77 +234,1025 @@ func handlerNotFound() fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		ctx.Response.SetStatusCode(fasthttp.StatusNotFound)
 		ctx.Response.SetBodyString("Not Found")
-		log.Printf(logf, ctx.Method(), ctx.Path(), fasthttp.StatusNotFound, "Not Found")
+		logRequest(ctx, fasthttp.StatusNotFound, "Not Found")
+	}
+}
+
+func handlerGone() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.SetStatusCode(fasthttp.StatusGone)
+		ctx.Response.SetBodyString("Gone")
+		logRequest(ctx, fasthttp.StatusGone, "Gone")
 	}
 }
 
 func handlerReader(ident string, rd *bytes.Reader) fasthttp.RequestHandler {
+	b := make([]byte, rd.Size())
+	rd.ReadAt(b, 0)
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(b))
 	return func(ctx *fasthttp.RequestCtx) {
-		rd.Seek(0, 0)
-		rd.WriteTo(ctx)
+		if checkETag(ctx, etag) {
+			return
+		}
+		// concurrent requests share the handler, so the reader isn't seeked
+		ctx.Write(b)
 		ctx.Response.Header.Set("Content-Type", "text/html; charset=utf-8")
-		log.Printf(logf, ctx.Method(), ctx.Path(), fasthttp.StatusOK, ident)
+		logRequest(ctx, ctx.Response.StatusCode(), ident)
+	}
+}
+
+// handlerHeader wraps a handler so that it responds with the given header.
+func handlerHeader(name, value string, h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set(name, value)
+		h(ctx)
 	}
 }
 
-func handlerRedirect(url string) fasthttp.RequestHandler {
-	url = strings.TrimSpace(url)
+// handlerStatus wraps a handler so that it responds with the given status.
+func handlerStatus(code int, h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.SetStatusCode(code)
+		h(ctx)
+	}
+}
+
+// redirectTarget gives the target of a redirect file, its first line that
+// isn't blank, so CRLF line endings and trailing lines from an editor
+// don't end up in the Location header.
+func redirectTarget(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+func handlerRedirect(content string) fasthttp.RequestHandler {
+	url := redirectTarget(content)
 	return func(ctx *fasthttp.RequestCtx) {
 		ctx.Response.SetStatusCode(fasthttp.StatusPermanentRedirect)
 		ctx.Response.Header.Set("Location", url)
-		log.Printf(logf, ctx.Method(), ctx.Path(), fasthttp.StatusPermanentRedirect, "")
+		logRequest(ctx, fasthttp.StatusPermanentRedirect, "")
 	}
 }
 
+// Handling of templates that can't be parsed.
+const (
+	templateErrorsFallback = "fallback"
+	templateErrorsFail     = "fail"
+	templateErrorsDegrade  = "degrade"
+)
+
+// loadTemplate parses the template file, giving the default template if
+// none is given. If it can't be read or parsed, the error is given along
+// with the default template, or with a broken one if pages using it should
+// fail instead.
+func (s *server) loadTemplate(name, filename string) (*template.Template, error) {
+	if filename == "" {
+		return template.Must(template.New(name).Parse(defaultTpl)), nil
+	}
+	t := template.New(name)
+	tpl, err := ioutil.ReadFile(filename)
+	if err == nil {
+		_, err = t.Parse(string(tpl))
+	}
+	if err != nil {
+		// couldn't parse template
+		err = fmt.Errorf("couldn't load template %s: %v", filename, err)
+		if s.templateErrors == templateErrorsDegrade {
+			return brokenTemplate(name, err), err
+		}
+		return template.Must(template.New(name).Parse(defaultTpl)), err
+	}
+	return t, nil
+}
+
+// brokenTemplate gives a template that fails with err when executed, so
+// the pages using a template that couldn't be parsed fail to render.
+func brokenTemplate(name string, err error) *template.Template {
+	broken := func() (string, error) { return "", err }
+	return template.Must(template.New(name).Funcs(template.FuncMap{"broken": broken}).Parse("{{ broken }}"))
+}
+
 // settings is unmarshalled from a yaml file according to this
 // specification.
 type settings struct {
-	Host     string            // optional, defaults to kernal-reported hostname
-	Dir      string            // optional, defaults to directory of settings file
-	Port     string            // optional, defaults to '80'
-	Template string            // required
-	Log      string            // optional, defaults to stdout
-	Secrets  map[string]string // optional
-	TTL      int               // optional, defaults to '0' minutes
-	TLS      struct {          // optional
-		Only     bool   // optional
-		Required string // optional, 'all' or 'secrets'
-		Port     string // optional, defaults to '443'
-		Cert     string // required for TLS
-		Privkey  string // required for TLS
+	Host             string              // optional, defaults to kernal-reported hostname
+	Dir              string              // optional, defaults to directory of settings file
+	Autoindex        bool                // optional, list directories without an index file
+	LastCommit       bool                `yaml:"last_commit"` // optional, give templates the last git commit of each page
+	IndexNames       []string            `yaml:"index_names"` // optional, names of directory index files, defaults to [index]
+	DirDefaults      map[string]string   // optional, redirect targets for directories without an index
+	Extensions       []string            // optional, extension precedence when matching files by name
+	DirFile          string              `yaml:"dir_file"` // optional, 'fail' (default) or 'serve' when dir is a file
+	Port             string              // optional, defaults to '80'
+	Listen           string              // optional, address of the interface to bind or 'unix:' and a socket path, defaults to all
+	ListenMode       string              `yaml:"listen_mode"`     // optional, file mode of the unix socket, defaults to 0660
+	ProxyProtocol    bool                `yaml:"proxy_protocol"`  // optional, read client addresses from PROXY protocol headers
+	TrustedProxies   []string            `yaml:"trusted_proxies"` // optional, CIDRs of proxies whose X-Forwarded-* headers are honored
+	Template         string              // required
+	Templates        map[string]string   // optional, alternate templates by name
+	TemplateErrors   string              `yaml:"template_errors"` // optional, 'fallback' (default), 'fail', or 'degrade'
+	Fragments        bool                // optional, serve htmx requests without the template
+	LinkStyle        string              `yaml:"link_style"`  // optional, 'clean' (default), 'index', or 'html'
+	EarlyHints       []string            `yaml:"early_hints"` // optional, critical assets preloaded with 103 Early Hints
+	Language         string              // optional, default language of localized files
+	DirSlashRedirect *bool               // optional, defaults to true
+	Fallback         string              // optional, page served with 200 when nothing matches
+	NotFound         string              // optional, page served with 404 when nothing matches
+	Errors           map[int]string      // optional, error pages by status code
+	Gone             []string            // optional, paths or prefixes ending in '/' answered with 410
+	Render           []string            // optional, extensions to render, defaults to md, markdown, pug, jade, redirect
+	Asciidoctor      string              // optional, asciidoctor command, enables rendering adoc and asciidoc
+	RSTCommand       string              `yaml:"rst_command"` // optional, reStructuredText converter command, enables rendering rst
+	WellKnown        map[string]struct { // optional, special root paths
+		File    string // one of file, dir, or content
+		Dir     string
+		Content string
+	}
+	FollowSymlinks *bool             `yaml:"follow_symlinks"` // optional, defaults to true
+	Mounts         map[string]string // optional, directories served under URL prefixes
+	Vhosts         []vhostSettings   // optional, sites served by Host header
+	VhostUnknown   string            `yaml:"vhost_unknown"` // optional, 'default' (default) or 'notfound'
+	Metrics        string            // optional, path of Prometheus metrics, defaults to off
+	Reload         struct {          // optional, signed requests that flush the cache
+		Path     string // optional, defaults to /_reload
+		Secret   string // optional, HMAC secret signing reload requests, disabled if empty
+		Settings bool   // optional, also reload the settings like SIGHUP
+	}
+	Git struct { // optional, serve dir as a working copy of a git repository
+		Repo    string // optional, URL of the repository, disabled if empty
+		Branch  string // optional, defaults to 'main'
+		Poll    int    // optional, minutes between pulls, defaults to never
+		Webhook string // optional, path of push notifications that trigger a pull
+		Secret  string // optional, HMAC secret signing webhook requests, required with webhook
+	}
+	Health struct { // optional, paths of load balancer probes
+		Live  string // optional, e.g. /healthz, off if empty
+		Ready string // optional, e.g. /readyz, off if empty
+	}
+	Feed struct { // optional, feed of markdown files
+		Path   string // optional, route of the feed, disabled if empty
+		Title  string // optional
+		Dir    string // optional, directory of posts, defaults to dir
+		Limit  int    // optional, most recent posts listed, defaults to 20
+		Format string // optional, 'atom' or 'rss' (default)
+	}
+	Log           string                 // optional, defaults to stdout
+	LogFormat     string                 `yaml:"log_format"`      // optional, 'text' (default) or 'json'
+	LogMaxSizeMB  int                    `yaml:"log_max_size_mb"` // optional, rotate the log file past this size
+	LogMaxBackups int                    `yaml:"log_max_backups"` // optional, rotated log files kept, defaults to 3
+	Debug         bool                   // optional, show clients why requests failed
+	Secrets       map[string]routeSecret // optional
+	AuthScheme    string                 `yaml:"auth_scheme"`    // optional, 'basic' or 'digest' (default)
+	AuthNonceTTL  int                    `yaml:"auth_nonce_ttl"` // optional, defaults to '5' minutes
+	AuthExempt    []string               // optional, paths in secured routes served without auth
+	CORSOrigins   []string               `yaml:"cors_origins"` // optional, origins allowed credentialed requests to secured routes
+	SecurityTxt   *securityTxtSettings   // optional, generated /.well-known/security.txt
+	Admin         struct {               // optional
+		Path   string      // optional, prefix of admin endpoints, disabled if empty
+		Secret routeSecret // required with path, bearer token for admin requests
+	}
+	TTL                int                 // optional, defaults to '0' minutes, -1 for forever
+	Watch              bool                // optional, evict cached pages as their files change
+	MaxAge             map[string]int      // optional, Cache-Control max-age by route (in seconds)
+	CachePolicy        map[string]string   // optional, 'nostore' by route
+	Delay              map[string]int      // optional, testing only, milliseconds to delay responses by path prefix
+	QueryVary          map[string][]string // optional, query arguments templates see by route, or '*'
+	Compression        bool                // optional, defaults to false
+	Headers            map[string]string   // optional, sent with every response
+	OddNames           string              `yaml:"odd_names"`        // optional, 'reject' (default) or 'redirect'
+	MaxBodySize        int                 `yaml:"max_body_size"`    // optional, defaults to 4 MiB
+	MaxConnsPerIP      int                 `yaml:"max_conns_per_ip"` // optional, defaults to unlimited
+	MaxPathDepth       int                 // optional, defaults to 32 path segments
+	CacheMaxEntryBytes int                 `yaml:"cache_max_entry_bytes"` // optional, defaults to no limit
+	CacheMaxBytes      int                 `yaml:"cache_max_bytes"`       // optional, defaults to no limit
+	CacheByExtension   map[string]struct { // optional, caching by file extension, overriding ttl and maxage
+		TTL    int  // minutes in the server cache, negative for forever, '0' for never
+		MaxAge *int `yaml:"max_age"` // optional, client max-age in seconds, defaults to ttl
+	} `yaml:"cache_by_extension"`
+	Download struct { // optional
+		Zip      bool  // optional, allow '?download=zip' on directories
+		MaxFiles int   // optional, defaults to no limit
+		MaxBytes int64 // optional, defaults to no limit
+	}
+	Timeouts struct { // optional, in seconds, defaults to none
+		Read  int // optional, to read a request, including its body
+		Write int // optional, to write a response
+		Idle  int // optional, to wait for the next request on a keep-alive connection
+	}
+	Markdown struct { // optional
+		WarnTime   int    // optional, render time in milliseconds
+		WarnSize   int    // optional, rendered size in bytes
+		Reject     bool   // optional, fail renders past a threshold
+		RawHTML    string // optional, 'strip', 'escape', or 'allow' (default)
+		Engine     string // optional, 'goldmark' or 'blackfriday' (default)
+		CommonMark bool   // optional, strict CommonMark rendering via goldmark
+		ValidUTF8  bool   // optional, replace invalid UTF-8 in rendered pages
+		TOC        bool   // optional, build a table of contents for every page
+		TOCJSON    string `yaml:"toc_json"` // optional, 'header' or 'query', headings as JSON, defaults to off
+		Figures    bool   // optional, number figures and tables on every page
+		ImageSizes bool   `yaml:"image_sizes"` // optional, add the width and height of local images
+
+		// optional, extensions to turn on or off, defaulting to those of
+		// blackfriday.MarkdownCommon
+		Tables          *bool
+		FencedCode      *bool `yaml:"fenced_code"`
+		Autolink        *bool
+		Strikethrough   *bool
+		HardWrap        *bool `yaml:"hard_wrap"`
+		Footnotes       *bool
+		DefinitionLists *bool `yaml:"definition_lists"`
+		HeaderIDs       *bool `yaml:"header_ids"`
+	}
+	Highlight struct { // optional
+		Autodetect bool // optional, mark code blocks without a language with a guessed one
+	}
+	Slug struct { // optional, anchors of headings without one
+		Lowercase *bool  // optional, defaults to true
+		Separator string // optional, defaults to '-'
+		Strip     *bool  // optional, drop all but letters and digits, defaults to true
+	}
+	TLS struct { // optional
+		Only         bool         // optional
+		Required     string       // optional, 'all' or 'secrets'
+		Port         string       // optional, defaults to '443'
+		Cert         string       // required for TLS, unless acme is enabled
+		Privkey      string       // required for TLS, unless acme is enabled
+		HTTP3        bool         // optional
+		RedirectCode int          `yaml:"redirect_code"` // optional, defaults to 303
+		HSTS         hstsSettings `yaml:"hsts"`          // optional
+		ACME         struct {     // optional, automatic certificates
+			Enabled  bool
+			Email    string   // optional, contact for the certificate authority
+			CacheDir string   `yaml:"cache_dir"` // optional, defaults to 'acme-cache'
+			Hosts    []string // optional, defaults to host
+		} `yaml:"acme"`
 	} `yaml:"tls"`
 }
 
-// toServer creates a server from the settings struct. The server host is
-// determined using the host name reported by the kernel.
+// applyDefaults fills in the settings that were left unspecified. The
+// server host is determined using the host name reported by the kernel.
+func (st *settings) applyDefaults() {
+	if st.Port == "" && !st.TLS.Only {
+		st.Port = "80"
+	}
+	if st.Host == "" {
+		if host, err := os.Hostname(); err == nil {
+			st.Host = host
+		} else {
+			st.Host = "localhost"
+		}
+	}
+	if len(st.IndexNames) == 0 {
+		st.IndexNames = []string{"index"}
+	}
+	if st.DirSlashRedirect == nil {
+		redirect := true
+		st.DirSlashRedirect = &redirect
+	}
+	if st.AuthScheme == "" {
+		st.AuthScheme = "digest"
+	}
+	if st.AuthNonceTTL <= 0 {
+		st.AuthNonceTTL = 5
+	}
+	if st.Markdown.RawHTML == "" {
+		st.Markdown.RawHTML = "allow"
+	}
+	if st.Markdown.Engine == "" && st.Markdown.CommonMark {
+		st.Markdown.Engine = "goldmark"
+	} else if st.Markdown.Engine == "" {
+		st.Markdown.Engine = "blackfriday"
+	}
+	if st.LogMaxSizeMB > 0 && st.LogMaxBackups <= 0 {
+		st.LogMaxBackups = 3
+	}
+	if st.Reload.Secret != "" && st.Reload.Path == "" {
+		st.Reload.Path = "/_reload"
+	}
+	if st.Git.Repo != "" && st.Git.Branch == "" {
+		st.Git.Branch = "main"
+	}
+	if st.MaxPathDepth <= 0 {
+		st.MaxPathDepth = 32
+	}
+	if st.Feed.Path != "" && st.Feed.Limit <= 0 {
+		st.Feed.Limit = 20
+	}
+	if st.Render == nil {
+		st.Render = []string{"md", "markdown", "pug", "jade", "redirect"}
+		if st.Asciidoctor != "" {
+			st.Render = append(st.Render, "adoc", "asciidoc")
+		}
+		if st.RSTCommand != "" {
+			st.Render = append(st.Render, "rst")
+		}
+	}
+	if st.TLS.ACME.Enabled && len(st.TLS.ACME.Hosts) == 0 {
+		st.TLS.ACME.Hosts = []string{st.Host}
+		for _, v := range st.Vhosts {
+			st.TLS.ACME.Hosts = append(st.TLS.ACME.Hosts, v.Host)
+		}
+	}
+	if st.TLS.Cert != "" && st.TLS.Privkey != "" || st.TLS.ACME.Enabled {
+		if st.TLS.Port == "" {
+			st.TLS.Port = "443"
+		}
+		if st.TLS.Required == "" {
+			st.TLS.Required = "none"
+		}
+		if st.TLS.RedirectCode == 0 {
+			st.TLS.RedirectCode = 303
+		}
+		if st.TLS.HSTS.MaxAge == 0 {
+			st.TLS.HSTS.MaxAge = 63072000
+		}
+	}
+}
+
+// validate checks the settings for problems that would leave the server
+// half-working or keep it from starting, giving an error naming the field
+// for each. toServer relies on it, so every setting it takes is checked
+// here.
+func (st settings) validate() []error {
+	st.applyDefaults()
+	var errs []error
+	bad := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	// files
+	if f, err := os.Open(st.Dir); err != nil {
+		// a repository is cloned at startup
+		if st.Git.Repo == "" || !os.IsNotExist(err) {
+			bad("bad 'dir' field, couldn't open %s: %v", st.Dir, err)
+		}
+	} else {
+		if fi, err := f.Stat(); err == nil && fi.IsDir() {
+			if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+				bad("bad 'dir' field, couldn't read %s: %v", st.Dir, err)
+			}
+		} else if err == nil && st.DirFile != dirFileServe {
+			bad("bad 'dir' field, %s is a file; set 'dir_file: serve' to serve it for every request", st.Dir)
+		}
+		f.Close()
+	}
+	switch st.DirFile {
+	case "", dirFileFail, dirFileServe:
+	default:
+		bad("bad 'dir_file' field, should be 'fail' or 'serve'")
+	}
+	// templates may come from the repository too
+	fromGit := func(filename string) bool {
+		return st.Git.Repo != "" && strings.HasPrefix(filename, st.Dir+string(fp.Separator))
+	}
+	if st.Template != "" {
+		if _, err := os.Stat(st.Template); err != nil && !fromGit(st.Template) {
+			bad("bad 'template' field, couldn't open %s: %v", st.Template, err)
+		}
+	}
+	for name, filename := range st.Templates {
+		if _, err := os.Stat(filename); err != nil && !fromGit(filename) {
+			bad("bad 'templates' field, couldn't open %s for '%s': %v", filename, name, err)
+		}
+	}
+	switch st.TemplateErrors {
+	case "", templateErrorsFallback, templateErrorsFail, templateErrorsDegrade:
+	default:
+		bad("bad 'template_errors' field, should be 'fallback', 'fail', or 'degrade'")
+	}
+	for prefix, root := range st.Mounts {
+		if !strings.HasPrefix(prefix, "/") || root == "" {
+			bad("bad 'mounts' field for '%s'", strings.TrimSuffix(prefix, "/"))
+		}
+	}
+	hosts := make(map[string]bool)
+	for _, v := range st.Vhosts {
+		host := strings.ToLower(v.Host)
+		if host == "" || v.Dir == "" {
+			bad("bad 'vhosts' field, each needs a host and dir")
+		} else if hosts[host] {
+			bad("bad 'vhosts' field, '%s' is listed twice", v.Host)
+		} else if _, err := os.Stat(v.Dir); err != nil {
+			bad("bad 'vhosts' field, couldn't open %s for %s: %v", v.Dir, v.Host, err)
+		}
+		if v.Template != "" {
+			if _, err := os.Stat(v.Template); err != nil {
+				bad("bad 'vhosts' field, couldn't open %s for %s: %v", v.Template, v.Host, err)
+			}
+		}
+		hosts[host] = true
+	}
+	if len(st.Vhosts) > 0 {
+		switch st.VhostUnknown {
+		case "", vhostUnknownDefault, vhostUnknownNotFound:
+		default:
+			bad("bad 'vhost_unknown' field, should be 'default' or 'notfound'")
+		}
+	}
+
+	// listening
+	if strings.HasPrefix(st.Listen, "unix:") {
+		mode, err := strconv.ParseUint(st.ListenMode, 8, 32)
+		if st.ListenMode == "" {
+			mode, err = 0660, nil
+		}
+		if st.Listen == "unix:" || err != nil || mode > 0777 {
+			bad("bad 'listen' field, needs a socket path and an octal 'listen_mode'")
+		}
+	} else if listen := strings.TrimSuffix(strings.TrimPrefix(st.Listen, "["), "]"); strings.Contains(listen, ":") && net.ParseIP(listen) == nil {
+		bad("bad 'listen' field, '%s' isn't a host or IP address", st.Listen)
+	}
+	for _, cidr := range st.TrustedProxies {
+		if _, err := parseTrustedProxy(cidr); err != nil {
+			bad("bad 'trusted_proxies' field, '%s' isn't an address or CIDR", cidr)
+		}
+	}
+	if st.MaxConnsPerIP < 0 {
+		bad("bad 'max_conns_per_ip' field")
+	}
+	if st.Timeouts.Read < 0 || st.Timeouts.Write < 0 || st.Timeouts.Idle < 0 {
+		bad("bad 'timeouts' field, should be nonnegative seconds")
+	}
+
+	// TLS and authentication
+	doTLS := st.TLS.Cert != "" && st.TLS.Privkey != "" || st.TLS.ACME.Enabled
+	switch {
+	case st.TLS.Cert != "" && st.TLS.Privkey == "":
+		bad("bad 'tls.cert' field, needs a 'tls.privkey'")
+	case st.TLS.Cert == "" && st.TLS.Privkey != "":
+		bad("bad 'tls.privkey' field, needs a 'tls.cert'")
+	}
+	for _, field := range []struct{ name, filename string }{{"tls.cert", st.TLS.Cert}, {"tls.privkey", st.TLS.Privkey}} {
+		if field.filename == "" {
+			continue
+		}
+		if _, err := os.Stat(field.filename); err != nil {
+			bad("bad '%s' field, couldn't open %s: %v", field.name, field.filename, err)
+		}
+	}
+	if doTLS {
+		switch st.TLS.RedirectCode {
+		case fasthttp.StatusMovedPermanently, fasthttp.StatusFound, fasthttp.StatusSeeOther,
+			fasthttp.StatusTemporaryRedirect, fasthttp.StatusPermanentRedirect:
+		default:
+			bad("bad 'tls.redirect_code' field, should be 301, 302, 303, 307, or 308")
+		}
+		if _, ok := tlsRequired[st.TLS.Required]; !ok {
+			bad("bad 'tls.required' field")
+		}
+	}
+	scheme, ok := authSchemes[st.AuthScheme]
+	if !ok {
+		bad("bad 'auth_scheme' field")
+	} else {
+		if err := checkSecrets(scheme, st.Secrets); err != nil {
+			errs = append(errs, err)
+		}
+		for _, v := range st.Vhosts {
+			if err := checkSecrets(scheme, v.Secrets); err != nil {
+				bad("vhost %s: %v", v.Host, err)
+			}
+		}
+	}
+	switch {
+	case scheme != authBasic:
+	case !doTLS:
+		bad("'auth_scheme: basic' requires TLS")
+	case !st.TLS.Only && st.TLS.Required == "none":
+		bad("'auth_scheme: basic' requires 'tls.only' or 'tls.required'")
+	}
+	for _, pattern := range st.AuthExempt {
+		if _, err := path.Match(pattern, ""); err != nil {
+			bad("bad 'authexempt' pattern '%s'", pattern)
+		}
+	}
+	for _, origin := range st.CORSOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			bad("bad 'cors_origins' field, '%s' isn't an origin like https://example.com", origin)
+		}
+	}
+	if st.Admin.Path != "" && st.Admin.Secret.password == "" {
+		bad("'admin.path' requires 'admin.secret'")
+	}
+	if st.Git.Repo != "" && st.Git.Webhook != "" && st.Git.Secret == "" {
+		bad("bad 'git.webhook' field, needs a 'git.secret'")
+	}
+
+	// paths
+	for _, p := range st.Gone {
+		if !strings.HasPrefix(p, "/") {
+			bad("bad 'gone' field, '%s' should start with '/'", p)
+		}
+	}
+	for _, name := range st.IndexNames {
+		if name == "" || strings.ContainsAny(name, "/\\") {
+			bad("bad 'index_names' field, '%s' isn't a file name", name)
+		}
+	}
+	for dir, target := range st.DirDefaults {
+		if !strings.HasPrefix(dir, "/") || target == "" {
+			bad("bad 'dirdefaults' field, '%s' should start with '/' and have a target", dir)
+		}
+	}
+	for _, ext := range st.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			bad("bad 'extensions' field, '%s' should start with '.'", ext)
+		}
+	}
+	for prefix, ms := range st.Delay {
+		if !strings.HasPrefix(prefix, "/") || ms < 0 {
+			bad("bad 'delay' field, '%s' should start with '/' and have a nonnegative delay", prefix)
+		}
+	}
+	for field, p := range map[string]string{"health.live": st.Health.Live, "health.ready": st.Health.Ready} {
+		if p != "" && !strings.HasPrefix(p, "/") {
+			bad("bad '%s' field, should start with '/'", field)
+		}
+	}
+	if st.Metrics != "" && !strings.HasPrefix(st.Metrics, "/") {
+		bad("bad 'metrics' field, should start with '/'")
+	}
+	for route := range st.WellKnown {
+		if !strings.HasPrefix(route, "/") {
+			bad("bad 'wellknown' field, '%s' should start with '/'", route)
+		}
+	}
+	if st.SecurityTxt != nil {
+		if _, ok := st.WellKnown[securityTxtPath]; ok {
+			bad("bad 'securitytxt' field, %s is also under 'wellknown'", securityTxtPath)
+		}
+		if _, err := st.SecurityTxt.content(st.Host); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if st.Feed.Path != "" {
+		switch st.Feed.Format {
+		case "", "rss", "atom":
+		default:
+			bad("bad 'feed.format' field")
+		}
+	}
+
+	// caching
+	if st.TTL < -1 {
+		bad("bad 'ttl' field, should be -1 for forever or a nonnegative number of minutes")
+	}
+	if st.Watch && st.TTL == 0 {
+		bad("bad 'watch' field, needs a 'ttl'")
+	}
+	for route, policy := range st.CachePolicy {
+		if policy != cacheNoStore {
+			bad("bad 'cachepolicy' field for route '%s'", route)
+		}
+	}
+	for ext, policy := range st.CacheByExtension {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || policy.MaxAge != nil && *policy.MaxAge < 0 {
+			bad("bad 'cache_by_extension' field, '%s' should start with '.' and have a nonnegative max_age", ext)
+		}
+	}
+
+	// responses
+	for name := range st.Headers {
+		switch strings.ToLower(name) {
+		case "content-type", "content-length", "content-encoding":
+			bad("bad 'headers' field, '%s' is set per response", name)
+		}
+	}
+	switch st.LinkStyle {
+	case "", linkStyleClean, linkStyleIndex, linkStyleHTML:
+	default:
+		bad("bad 'link_style' field, should be 'clean', 'index', or 'html'")
+	}
+	switch st.LogFormat {
+	case "", logFormatText, logFormatJSON:
+	default:
+		bad("bad 'log_format' field, should be 'text' or 'json'")
+	}
+	switch st.OddNames {
+	case "", oddNamesReject, oddNamesRedirect:
+	default:
+		bad("bad 'odd_names' field")
+	}
+
+	// rendering
+	for _, ext := range st.Render {
+		if strings.TrimPrefix(ext, ".") == "rst" && len(strings.Fields(st.RSTCommand)) == 0 {
+			bad("bad 'render' field, rendering rst needs 'rst_command'")
+		}
+	}
+	switch st.Markdown.TOCJSON {
+	case "", tocJSONHeader, tocJSONQuery:
+	default:
+		bad("bad 'markdown.toc_json' field, should be 'header' or 'query'")
+	}
+	switch st.Markdown.RawHTML {
+	case "allow", "strip", "escape":
+	default:
+		bad("bad 'markdown.rawhtml' field")
+	}
+	switch st.Markdown.Engine {
+	case "blackfriday":
+		if st.Markdown.CommonMark {
+			bad("'markdown.commonmark' requires the goldmark engine")
+		}
+	case "goldmark":
+		if st.Markdown.RawHTML == "escape" {
+			bad("'markdown.rawhtml: escape' isn't supported by goldmark")
+		}
+	default:
+		bad("bad 'markdown.engine' field")
+	}
+	return errs
+}
+
+// parseTrustedProxy parses an entry of 'trusted_proxies', a single address
+// or a CIDR.
+func parseTrustedProxy(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		// a single address
+		if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
+
+// warnings gives what the settings leave to defaults that are rarely
+// wanted, which the server starts with anyway but --check reports.
+func (st settings) warnings() []string {
+	var warnings []string
+	if st.Template == "" {
+		warnings = append(warnings, "no 'template' field, pages use the bare default template")
+	}
+	for _, v := range st.Vhosts {
+		if v.Template == "" {
+			warnings = append(warnings, fmt.Sprintf("no 'template' field for vhost %s, its pages use the bare default template", v.Host))
+		}
+	}
+	return warnings
+}
+
+// redacted gives the settings without their secrets, for printing. Route
+// and admin secrets redact themselves.
+func (st settings) redacted() settings {
+	if st.Git.Secret != "" {
+		st.Git.Secret = "REDACTED"
+	}
+	if st.Reload.Secret != "" {
+		st.Reload.Secret = "REDACTED"
+	}
+	// credentials may be in the repository URL, as user:token@host
+	if u, err := url.Parse(st.Git.Repo); err == nil && u.User != nil {
+		u.User = nil
+		st.Git.Repo = u.String()
+	}
+	return st
+}
+
+// hstsSettings configures the Strict-Transport-Security header.
+type hstsSettings struct {
+	Disabled          bool // optional, don't send the header
+	MaxAge            int  `yaml:"max_age"` // optional, defaults to 63072000 seconds
+	IncludeSubdomains bool `yaml:"include_subdomains"`
+	Preload           bool
+}
+
+// header gives the Strict-Transport-Security header value, or the empty
+// string if it is disabled.
+func (h hstsSettings) header() string {
+	if h.Disabled {
+		return ""
+	}
+	value := fmt.Sprintf("max-age=%d", h.MaxAge)
+	if h.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if h.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// toServer creates a server from the settings struct.
 func (st settings) toServer() *server {
+	if errs := st.validate(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	st.applyDefaults()
+	s := st.siteServer()
+
+	s.nonceKey = make([]byte, 32)
+	if _, err := rand.Read(s.nonceKey); err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't generate nonce key")
+		os.Exit(1)
+	}
+
+	if st.TLS.Cert != "" && st.TLS.Privkey != "" || st.TLS.ACME.Enabled {
+		st.configureTLS(s)
+	}
+	if len(st.Vhosts) > 0 {
+		s.vhostUnknown = st.VhostUnknown
+		if s.vhostUnknown == "" {
+			s.vhostUnknown = vhostUnknownDefault
+		}
+		s.vhosts = st.vhostServers(s)
+	}
+	return s
+}
+
+// siteServer creates the server of a site from validated settings with
+// defaults applied, leaving out what's shared with virtual hosts: the
+// nonce key, TLS, and the virtual hosts themselves.
+func (st settings) siteServer() *server {
 	s := new(server)
 	s.path = st.Dir
+	s.mounts = newMounts(st.Mounts)
+	s.noFollowSymlinks = st.FollowSymlinks != nil && !*st.FollowSymlinks
+	if fi, err := os.Stat(st.Dir); err == nil && !fi.IsDir() {
+		s.singleFile = true
+	}
 	if !st.TLS.Only {
 		s.port = st.Port
-		if s.port == "" {
-			s.port = "80"
-		}
 	}
 	s.host = st.Host
-	if s.host == "" {
-		if host, err := os.Hostname(); err == nil {
-			s.host = host
-		} else {
-			s.host = "localhost"
+	if strings.HasPrefix(st.Listen, "unix:") {
+		s.socket = strings.TrimPrefix(st.Listen, "unix:")
+		s.socketMode = 0660
+		if st.ListenMode != "" {
+			mode, _ := strconv.ParseUint(st.ListenMode, 8, 32)
+			s.socketMode = os.FileMode(mode)
 		}
+	} else {
+		s.listen = strings.TrimSuffix(strings.TrimPrefix(st.Listen, "["), "]")
 	}
-	s.mdTemplate = template.New("tpl")
-	tpl, err := ioutil.ReadFile(st.Template)
-	if err == nil {
-		_, err = s.mdTemplate.Parse(string(tpl))
+	s.proxyProtocol = st.ProxyProtocol
+	for _, cidr := range st.TrustedProxies {
+		network, _ := parseTrustedProxy(cidr)
+		s.trustedProxies = append(s.trustedProxies, network)
 	}
-	if err != nil {
-		// couldn't parse template
-		s.mdTemplate.Parse(defaultTpl)
+	s.reload.path = st.Reload.Path
+	s.reload.secret = st.Reload.Secret
+	s.reload.settings = st.Reload.Settings
+	if st.Git.Repo != "" {
+		s.git = &gitContent{
+			dir:     st.Dir,
+			repo:    st.Git.Repo,
+			branch:  st.Git.Branch,
+			poll:    time.Minute * time.Duration(st.Git.Poll),
+			webhook: st.Git.Webhook,
+			secret:  st.Git.Secret,
+		}
+		// templates and pages may come from the repository
+		if _, err := s.git.sync(); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't get git content: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	s.templateErrors = st.TemplateErrors
+	if s.templateErrors == "" {
+		s.templateErrors = templateErrorsFallback
+	}
+	var tplErrs []error
+	load := func(name, filename string) *template.Template {
+		t, err := s.loadTemplate(name, filename)
+		if err != nil {
+			tplErrs = append(tplErrs, err)
+		}
+		return t
+	}
+	s.mdTemplate = load("tpl", st.Template)
+	if len(st.Templates) > 0 {
+		s.templates = make(map[string]*template.Template)
+		for name, filename := range st.Templates {
+			s.templates[name] = load(name, filename)
+			s.templateNames = append(s.templateNames, name)
+		}
+		sort.Strings(s.templateNames)
+	}
+	s.health.templatesOK = len(tplErrs) == 0
+	switch s.templateErrors {
+	case templateErrorsFail:
+		tplErrs = append(tplErrs, s.checkDirTemplates()...)
+		for _, err := range tplErrs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(tplErrs) > 0 {
+			os.Exit(1)
+		}
+	case templateErrorsDegrade:
+		for _, err := range tplErrs {
+			fmt.Fprintf(os.Stderr, "%v, its pages will fail\n", err)
+		}
+	default:
+		for _, err := range tplErrs {
+			fmt.Fprintf(os.Stderr, "%v, using default\n", err)
+		}
+	}
+	if st.Fallback != "" {
+		s.fallback = fp.Join(st.Dir, fp.FromSlash(st.Fallback))
+	}
+	if len(st.Errors) > 0 || st.NotFound != "" {
+		s.errorPages = make(map[int]string)
+		for code, filename := range st.Errors {
+			s.errorPages[code] = fp.Join(st.Dir, fp.FromSlash(filename))
+		}
+		if st.NotFound != "" {
+			// shorthand for the 404 error page
+			s.errorPages[fasthttp.StatusNotFound] = fp.Join(st.Dir, fp.FromSlash(st.NotFound))
+		}
+	}
+	s.gone = st.Gone
+	s.autoindex = st.Autoindex
+	s.indexNames = st.IndexNames
+	s.dirDefaults = make(map[string]string)
+	for dir, target := range st.DirDefaults {
+		s.dirDefaults[strings.TrimSuffix(dir, "/")+"/"] = target
+	}
+	s.extensions = st.Extensions
+	s.lastCommit = st.LastCommit
+	s.slug = slugger{
+		preserveCase: st.Slug.Lowercase != nil && !*st.Slug.Lowercase,
+		separator:    st.Slug.Separator,
+		keepPunct:    st.Slug.Strip != nil && !*st.Slug.Strip,
+	}
+	s.noDirRedirect = st.DirSlashRedirect != nil && !*st.DirSlashRedirect
+	s.cachePolicy = st.CachePolicy
+	s.extCache = make(map[string]extCache)
+	for ext, policy := range st.CacheByExtension {
+		var ec extCache
+		switch {
+		case policy.TTL > 0:
+			ec.ttl = time.Minute * time.Duration(policy.TTL)
+			ec.maxAge = int(ec.ttl.Seconds())
+		case policy.TTL < 0:
+			ec.ttl = cache.NoExpiration
+			ec.maxAge = foreverMaxAge
+		default:
+			ec.maxAge = -1
+		}
+		if policy.MaxAge != nil {
+			ec.maxAge = *policy.MaxAge
+		}
+		s.extCache[strings.TrimPrefix(ext, ".")] = ec
+	}
+	s.delay = st.Delay
+	s.queryVary = st.QueryVary
+	s.maxAge = st.MaxAge
+	s.fragments = st.Fragments
+	s.headers = st.Headers
+	s.health.live = st.Health.Live
+	s.health.ready = st.Health.Ready
+	if st.Metrics != "" {
+		s.metrics = newMetrics()
+		s.metricsPath = st.Metrics
+	}
+	for _, asset := range st.EarlyHints {
+		s.earlyHints = append(s.earlyHints, preloadLink(asset))
+	}
+	s.linkStyle = st.LinkStyle
+	if s.linkStyle == "" {
+		s.linkStyle = linkStyleClean
+	}
+	s.logFormat = st.LogFormat
+	if s.logFormat == "" {
+		s.logFormat = logFormatText
+	}
+	s.oddNames = st.OddNames
+	if s.oddNames == "" {
+		s.oddNames = oddNamesReject
+	}
+	s.debug = st.Debug
+	s.language = strings.ToLower(st.Language)
+	s.asciidoctor = st.Asciidoctor
+	if s.asciidoctor == "" {
+		s.asciidoctor = "asciidoctor"
+	}
+	s.rstCommand = strings.Fields(st.RSTCommand)
+	s.renderable = make(map[string]bool)
+	for _, ext := range st.Render {
+		s.renderable[strings.TrimPrefix(ext, ".")] = true
+	}
+	for route, wk := range st.WellKnown {
+		if s.wellKnown == nil {
+			s.wellKnown = make(map[string]fasthttp.RequestHandler)
+			s.wellKnownDirs = make(map[string]string)
+		}
+		switch {
+		case wk.File != "":
+			s.wellKnown[route] = handlerMaxAge(wellKnownMaxAge, handlerLiteralFile(wk.File))
+		case wk.Dir != "":
+			s.wellKnownDirs[strings.TrimSuffix(route, "/")+"/"] = wk.Dir
+		default:
+			s.wellKnown[route] = handlerMaxAge(wellKnownMaxAge, handlerContent(route, wk.Content))
+		}
+	}
+	if st.SecurityTxt != nil {
+		content, _ := st.SecurityTxt.content(st.Host)
+		if s.wellKnown == nil {
+			s.wellKnown = make(map[string]fasthttp.RequestHandler)
+		}
+		s.wellKnown[securityTxtPath] = handlerMaxAge(wellKnownMaxAge, handlerContent(securityTxtPath, content))
 	}
 	s.secret = st.Secrets
+	s.authExemptions = st.AuthExempt
+	s.corsOrigins = st.CORSOrigins
+	if st.Feed.Path != "" {
+		s.feed = &feed{
+			path:   "/" + strings.TrimPrefix(st.Feed.Path, "/"),
+			title:  st.Feed.Title,
+			dir:    fp.Join(st.Dir, fp.FromSlash(st.Feed.Dir)),
+			limit:  st.Feed.Limit,
+			format: st.Feed.Format,
+		}
+	}
+	if st.Admin.Path != "" {
+		s.admin.path = "/" + strings.Trim(st.Admin.Path, "/")
+		s.admin.secret = st.Admin.Secret.password
+	}
+	s.render.warnTime = time.Millisecond * time.Duration(st.Markdown.WarnTime)
+	s.render.warnSize = st.Markdown.WarnSize
+	s.render.reject = st.Markdown.Reject
+	s.render.validUTF8 = st.Markdown.ValidUTF8
+	s.toc = st.Markdown.TOC
+	s.tocJSON = st.Markdown.TOCJSON
+	s.figures = st.Markdown.Figures
+	s.imageSizes = st.Markdown.ImageSizes
+	s.detectLanguages = st.Highlight.Autodetect
+	rawHTML := rawHTMLAllow
+	switch st.Markdown.RawHTML {
+	case "strip":
+		rawHTML = rawHTMLStrip
+	case "escape":
+		rawHTML = rawHTMLEscape
+	}
+	// extensions default to those of blackfriday.MarkdownCommon for either
+	// engine, while strict CommonMark gets none
+	extensions := commonExtensions
+	for name, on := range map[string]*bool{
+		"tables":           st.Markdown.Tables,
+		"fenced_code":      st.Markdown.FencedCode,
+		"autolink":         st.Markdown.Autolink,
+		"strikethrough":    st.Markdown.Strikethrough,
+		"hard_wrap":        st.Markdown.HardWrap,
+		"footnotes":        st.Markdown.Footnotes,
+		"definition_lists": st.Markdown.DefinitionLists,
+		"header_ids":       st.Markdown.HeaderIDs,
+	} {
+		switch {
+		case on == nil:
+		case *on:
+			extensions |= blackfridayExtensions[name]
+		default:
+			extensions &^= blackfridayExtensions[name]
+		}
+	}
+	var enabled []string
+	for name, ext := range blackfridayExtensions {
+		if extensions&ext != 0 {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	s.engines = map[string]markdownEngine{
+		"blackfriday": blackfridayEngine{rawHTML, extensions},
+	}
+	if rawHTML != rawHTMLEscape {
+		s.engines["goldmark"] = newGoldmarkEngine(rawHTML, false, enabled...)
+		s.engines["commonmark"] = newGoldmarkEngine(rawHTML, true)
+	}
+	s.markdown = s.engines["blackfriday"]
+	if st.Markdown.Engine == "goldmark" {
+		s.markdown = s.engines["goldmark"]
+		if st.Markdown.CommonMark {
+			s.markdown = s.engines["commonmark"]
+		}
+	}
 
+	s.zip.enabled = st.Download.Zip
+	s.zip.maxFiles = st.Download.MaxFiles
+	s.zip.maxBytes = st.Download.MaxBytes
+	s.maxBodySize = st.MaxBodySize
+	s.maxConnsPerIP = st.MaxConnsPerIP
+	s.timeouts.read = time.Second * time.Duration(st.Timeouts.Read)
+	s.timeouts.write = time.Second * time.Duration(st.Timeouts.Write)
+	s.timeouts.idle = time.Second * time.Duration(st.Timeouts.Idle)
+	s.compress = st.Compression
+	s.cacheEntryMax = st.CacheMaxEntryBytes
+	s.maxPathDepth = st.MaxPathDepth
+	if st.CacheMaxBytes > 0 {
+		s.cacheSizes = newCacheSizes(st.CacheMaxBytes)
+	}
+	s.watch = st.Watch
 	if st.TTL != 0 {
 		var t time.Duration
 		if st.TTL > 0 {
@@ -166,28 +1263,23 @@ func (st settings) toServer() *server {
 		s.ttl = &t
 	}
 
-	doTLS := st.TLS.Cert != "" && st.TLS.Privkey != ""
-	if !doTLS {
-		return s
-	}
+	s.authScheme = authSchemes[st.AuthScheme]
+
+	s.nonceTTL = time.Minute * time.Duration(st.AuthNonceTTL)
+	return s
+}
+
+// configureTLS sets up the server for TLS, which is enabled by a
+// certificate or ACME.
+func (st settings) configureTLS(s *server) {
 	s.tls.port = st.TLS.Port
-	if s.tls.port == "" {
-		s.tls.port = "443"
-	}
 	s.tls.cert = st.TLS.Cert
 	s.tls.key = st.TLS.Privkey
-	switch st.TLS.Required {
-	case "":
-		fallthrough
-	case "none":
-		s.tls.required = requiredNone
-	case "secrets":
-		s.tls.required = requiredSecrets
-	case "all":
-		s.tls.required = requiredAll
-	default:
-		fmt.Fprintln(os.Stderr, "bad 'tls.required' field")
-		os.Exit(1)
+	s.tls.http3 = st.TLS.HTTP3
+	s.tls.hsts = st.TLS.HSTS.header()
+	s.tls.redirectCode = st.TLS.RedirectCode
+	if st.TLS.ACME.Enabled {
+		s.tls.acme = newACMEManager(st.TLS.ACME.Email, st.TLS.ACME.CacheDir, st.TLS.ACME.Hosts)
 	}
-	return s
+	s.tls.required = tlsRequired[st.TLS.Required]
 }
@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	fp "path/filepath"
+	"sort"
+	"strings"
+)
+
+// mount serves a directory under a URL prefix.
+type mount struct {
+	prefix string // without a trailing slash
+	root   string
+}
+
+// newMounts gives the mounts from the settings, longest prefix first.
+func newMounts(mounts map[string]string) []mount {
+	var ms []mount
+	for prefix, root := range mounts {
+		ms = append(ms, mount{strings.TrimSuffix(prefix, "/"), root})
+	}
+	sort.Slice(ms, func(i, j int) bool { return len(ms[i].prefix) > len(ms[j].prefix) })
+	return ms
+}
+
+// resolve gives the file path of a request path, under the mount with the
+// longest matching prefix, or else under the served directory, along with
+// the root it's under.
+func (s *server) resolve(pathStr string) (root, path string) {
+	for _, m := range s.mounts {
+		if pathStr == m.prefix || strings.HasPrefix(pathStr, m.prefix+"/") {
+			return m.root, fp.Join(m.root, strings.TrimPrefix(pathStr, m.prefix))
+		}
+	}
+	return s.path, fp.Join(s.path, pathStr)
+}
+
+// contained reports whether a path, with symbolic links resolved, is
+// still within root. A path that doesn't exist is judged by its
+// directory. Unless following symbolic links, a path through any link
+// isn't contained either.
+func (s *server) contained(root, path string) bool {
+	realRoot, err := fp.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	real, err := fp.EvalSymlinks(path)
+	if err != nil {
+		dir, err := fp.EvalSymlinks(fp.Dir(path))
+		if err != nil {
+			return false
+		}
+		real = fp.Join(dir, fp.Base(path))
+	}
+	rel, err := fp.Rel(realRoot, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+		return false
+	}
+	if s.noFollowSymlinks {
+		lexical, err := fp.Rel(root, path)
+		return err == nil && lexical == rel
+	}
+	return true
+}
+
+// roots gives the served directory and the roots of the mounts.
+func (s *server) roots() []string {
+	roots := []string{s.path}
+	for _, m := range s.mounts {
+		roots = append(roots, m.root)
+	}
+	return roots
+}
+
+// rootOf gives the deepest of the roots containing dir, or the empty
+// string if none does.
+func (s *server) rootOf(dir string) string {
+	root := ""
+	for _, r := range s.roots() {
+		if (dir == r || strings.HasPrefix(dir, r+string(fp.Separator)) || r == string(fp.Separator)) && len(r) > len(root) {
+			root = r
+		}
+	}
+	return root
+}
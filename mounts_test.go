@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMounts(t *testing.T) {
+	s := newTestServer(t, `dir: site
+mounts:
+  /docs: documentation
+  /docs/api/: api
+  /blog: posts
+`, map[string]string{
+		"site/index.md":          "home",
+		"site/docs/page.md":      "shadowed",
+		"site/other/page.md":     "other",
+		"site/documents/page.md": "documents",
+		"documentation/page.md":  "docs page",
+		"documentation/index.md": "docs index",
+		"documentation/api/x.md": "shadowed api",
+		"api/x.md":               "api x",
+		"posts/first.md":         "first post",
+	})
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/docs/page", 200, "docs page"},
+		{"/docs/", 200, "docs index"},
+		{"/docs", 301, ""},
+		{"/blog/first", 200, "first post"},
+		// the longest prefix wins
+		{"/docs/api/x", 200, "api x"},
+		// no mount matches, so the served directory answers
+		{"/", 200, "home"},
+		{"/other/page", 200, "other"},
+		{"/documents/page", 200, "documents"},
+		{"/blog/missing", 404, ""},
+		{"/docs/../other/page", 200, "other"},
+	}
+	for _, tt := range tests {
+		resp := get(s, tt.path)
+		if body := string(resp.Body()); resp.StatusCode() != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("GET %s: got %d %q, want %d %q", tt.path, resp.StatusCode(), body, tt.status, tt.want)
+		}
+	}
+}
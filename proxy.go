@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/valyala/fasthttp"
+)
+
+// proxyHeaderTimeout bounds how long a connection may take to send its
+// PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// listenTCP creates a TCP listener at an address. With the PROXY protocol,
+// every connection must start with its header, version 1 or 2, and its
+// remote address is the client's from the header rather than the load
+// balancer's.
+func (s *server) listenTCP(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || !s.proxyProtocol {
+		return ln, err
+	}
+	return newEagerProxyListener(&proxyproto.Listener{
+		Listener: ln,
+		ConnPolicy: func(proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			// a header can't be told apart from a request that looks like
+			// one, so connections without it are refused
+			return proxyproto.REQUIRE, nil
+		},
+		ReadHeaderTimeout: proxyHeaderTimeout,
+	}), nil
+}
+
+// eagerProxyListener hands out connections only once their PROXY protocol
+// header is read, each in a goroutine of its own. fasthttp asks for the
+// remote address of a connection as it accepts it, for 'max_conns_per_ip',
+// which would otherwise read the header there and hold up every other
+// connection behind a slow one.
+type eagerProxyListener struct {
+	net.Listener
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newEagerProxyListener(ln *proxyproto.Listener) *eagerProxyListener {
+	l := &eagerProxyListener{
+		Listener: ln,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *eagerProxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go func() {
+			// the header is required, so it's only missing if it was bad or
+			// didn't come in time
+			if conn.(*proxyproto.Conn).ProxyHeader() == nil {
+				conn.Close()
+				return
+			}
+			select {
+			case l.conns <- conn:
+			case <-l.done:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// Accept gives the next connection whose header has been read.
+func (l *eagerProxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener. Connections whose header is still being read
+// are closed once it is.
+func (l *eagerProxyListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// trustedProxy reports whether an address is one of the trusted proxies.
+func (s *server) trustedProxy(ip net.IP) bool {
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkForwarded takes the scheme and client of a request that came
+// through a trusted proxy from its X-Forwarded-Proto and X-Forwarded-For
+// headers. They are ignored from anyone else, who could forge them.
+func (s *server) checkForwarded(ctx *fasthttp.RequestCtx) {
+	if !s.trustedProxy(ctx.RemoteIP()) {
+		return
+	}
+	proto := strings.SplitN(string(ctx.Request.Header.Peek("X-Forwarded-Proto")), ",", 2)[0]
+	if strings.EqualFold(strings.TrimSpace(proto), "https") {
+		ctx.SetUserValue("tls", true)
+	}
+	// each proxy appends the address it got the request from, so the
+	// client is the last one that isn't a trusted proxy
+	hops := strings.Split(string(ctx.Request.Header.Peek("X-Forwarded-For")), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		ctx.SetUserValue("clientIP", ip)
+		if !s.trustedProxy(ip) {
+			break
+		}
+	}
+}
+
+// clientIP gives the address of the client of a request, which is from
+// X-Forwarded-For if it came through trusted proxies.
+func clientIP(ctx *fasthttp.RequestCtx) net.IP {
+	if ip, ok := ctx.UserValue("clientIP").(net.IP); ok {
+		return ip
+	}
+	return ctx.RemoteIP()
+}
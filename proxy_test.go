@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/valyala/fasthttp"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	client := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51234}
+	balancer := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 80}
+	tests := []struct {
+		name    string
+		proxy   bool
+		version byte // of the header sent, if any
+		ip      string
+	}{
+		{"v1", true, 1, "203.0.113.7"},
+		{"v2", true, 2, "203.0.113.7"},
+		{"no header", true, 0, ""},
+		{"off", false, 0, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, "log_format: json\n", map[string]string{"page.md": "page"})
+		s.proxyProtocol = tt.proxy
+		ln, err := s.listenTCP("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp []byte
+		out := captureLog(func() {
+			go (&fasthttp.Server{Handler: s.handler()}).Serve(ln)
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if tt.version != 0 {
+				if _, err := proxyproto.HeaderProxyFromAddrs(tt.version, client, balancer).WriteTo(conn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn.Write([]byte("GET /page HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+			resp, _ = ioutil.ReadAll(conn)
+		})
+		ln.Close()
+		if tt.ip == "" {
+			// connections without a header are refused
+			if len(resp) != 0 || out != "" {
+				t.Errorf("%s: got %q, logged %q", tt.name, resp, out)
+			}
+			continue
+		}
+		if !strings.HasPrefix(string(resp), "HTTP/1.1 200 OK") {
+			t.Errorf("%s: got %q", tt.name, resp)
+		}
+		var entry accessEntry
+		if err := json.Unmarshal([]byte(out), &entry); err != nil || entry.RemoteIP != tt.ip {
+			t.Errorf("%s: logged %q, want remote IP %s", tt.name, out, tt.ip)
+		}
+	}
+}
+
+func TestProxyProtocolSlowHeader(t *testing.T) {
+	s := newTestServer(t, "", map[string]string{"page.md": "page"})
+	s.proxyProtocol = true
+	ln, err := s.listenTCP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// limiting connections per IP looks at each one's address as it's
+	// accepted
+	go (&fasthttp.Server{Handler: s.handler(), MaxConnsPerIP: 3}).Serve(ln)
+	slow, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 51234}
+	balancer := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 80}
+	if _, err := proxyproto.HeaderProxyFromAddrs(1, client, balancer).WriteTo(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET /page HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout / 2))
+	resp, err := ioutil.ReadAll(conn)
+	if err != nil || !strings.HasPrefix(string(resp), "HTTP/1.1 200 OK") {
+		t.Errorf("held up behind a connection without its header: got %q, %v", resp, err)
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxies  string // trusted, the test client being 192.0.2.1
+		proto    string
+		forwards string
+		redirect bool
+		ip       string
+	}{
+		{"trusted https", "[192.0.2.0/24]", "https", "198.51.100.9", false, "198.51.100.9"},
+		{"trusted http", "[192.0.2.0/24]", "http", "198.51.100.9", true, "198.51.100.9"},
+		{"trusted hops", "[192.0.2.0/24]", "https", "198.51.100.9, 192.0.2.5", false, "198.51.100.9"},
+		{"forged hop", "[192.0.2.1]", "https", "6.6.6.6, 198.51.100.9", false, "198.51.100.9"},
+		{"trusted without headers", "[192.0.2.0/24]", "", "", true, "192.0.2.1"},
+		{"untrusted", "[10.0.0.0/8]", "https", "198.51.100.9", true, "192.0.2.1"},
+		{"none trusted", "[]", "https", "198.51.100.9", true, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, tlsSettings+"  required: all\nlog_format: json\ntrusted_proxies: "+tt.proxies+"\n", tlsFiles)
+		var resp *fasthttp.Response
+		out := captureLog(func() {
+			resp = get(s, "http://example.com/page", "X-Forwarded-Proto", tt.proto, "X-Forwarded-For", tt.forwards)
+		})
+		if redirect := resp.StatusCode() == fasthttp.StatusSeeOther; redirect != tt.redirect {
+			t.Errorf("%s: got %d, want redirect %v", tt.name, resp.StatusCode(), tt.redirect)
+		}
+		var entry accessEntry
+		if err := json.Unmarshal([]byte(out), &entry); err != nil || entry.RemoteIP != tt.ip {
+			t.Errorf("%s: logged %q, want remote IP %s", tt.name, out, tt.ip)
+		}
+	}
+}
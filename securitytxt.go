@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// securityTxtPath is where security.txt is served, per RFC 9116.
+const securityTxtPath = "/.well-known/security.txt"
+
+// securityTxtSettings configures a generated security.txt.
+type securityTxtSettings struct {
+	Contact            []string // required, e.g. mailto: or https: URIs
+	Expires            string   // optional, RFC 3339, defaults to a year after startup
+	Encryption         string   // optional
+	Acknowledgments    string   // optional
+	PreferredLanguages string   `yaml:"preferred_languages"` // optional, e.g. 'en, fr'
+	Canonical          string   // optional, defaults to https://host/.well-known/security.txt
+	Policy             string   // optional
+	Hiring             string   // optional
+}
+
+// content gives the security.txt for a host.
+func (st securityTxtSettings) content(host string) (string, error) {
+	if len(st.Contact) == 0 {
+		return "", errors.New("bad 'securitytxt' field, needs a 'contact'")
+	}
+	expires := time.Now().AddDate(1, 0, 0)
+	if st.Expires != "" {
+		t, err := time.Parse(time.RFC3339, st.Expires)
+		if err != nil {
+			return "", fmt.Errorf("bad 'securitytxt.expires' field, should be RFC 3339: %v", err)
+		}
+		expires = t
+	}
+	canonical := st.Canonical
+	if canonical == "" {
+		canonical = "https://" + host + securityTxtPath
+	}
+	var b strings.Builder
+	for _, contact := range st.Contact {
+		fmt.Fprintf(&b, "Contact: %s\n", contact)
+	}
+	fmt.Fprintf(&b, "Expires: %s\n", expires.UTC().Format(time.RFC3339))
+	for _, field := range []struct{ name, value string }{
+		{"Encryption", st.Encryption},
+		{"Acknowledgments", st.Acknowledgments},
+		{"Preferred-Languages", st.PreferredLanguages},
+		{"Canonical", canonical},
+		{"Policy", st.Policy},
+		{"Hiring", st.Hiring},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", field.name, field.value)
+		}
+	}
+	return b.String(), nil
+}
@@ -19,22 +19,28 @@ package main
 
 import (
 	"bytes"
-	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	fp "path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Joker/jade"
 	"github.com/patrickmn/go-cache"
-	"github.com/russross/blackfriday"
 	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type server struct {
@@ -47,16 +53,324 @@ type server struct {
 	// host is the hostname of the server.
 	host string
 
-	// secret maps secured routes to their corresponding passwords.
-	secret map[string]string
+	// listen is the address of the interface the listeners bind to. If
+	// empty, they bind to all interfaces.
+	listen string
+
+	// socket is the Unix domain socket the HTTP server listens on in
+	// place of port, if set, created with socketMode.
+	socket     string
+	socketMode os.FileMode
+
+	// proxyProtocol reads the client address of TCP connections from a
+	// PROXY protocol header sent by a load balancer.
+	proxyProtocol bool
+
+	// trustedProxies are the networks of proxies whose X-Forwarded-For and
+	// X-Forwarded-Proto headers are believed.
+	trustedProxies []*net.IPNet
+
+	// noFollowSymlinks doesn't serve symbolic links, so paths through any
+	// link are not found.
+	noFollowSymlinks bool
+
+	// lastCommit gives templates the last commit of each markdown file,
+	// looked up once in commits until the cache is flushed.
+	lastCommit bool
+	commits    sync.Map
+
+	// imageSizes adds the width and height of local images to rendered
+	// markdown, looked up in images once per modification of each image.
+	imageSizes bool
+	images     sync.Map
+
+	// slug makes the anchors of headings without one.
+	slug slugger
+
+	// autoindex lists directories without an index file instead of
+	// answering 404.
+	autoindex bool
+
+	// indexNames are the names of directory index files, in order of
+	// precedence, and extensions is the order in which files matching a
+	// name are preferred, before any that aren't listed.
+	indexNames []string
+	extensions []string
+
+	// dirDefaults maps directories, with a trailing slash, to where
+	// requests for them redirect when they have no index.
+	dirDefaults map[string]string
+
+	// singleFile is set if the served directory is a file, which is served
+	// for every request.
+	singleFile bool
+
+	// mounts serve other directories under URL prefixes, longest first.
+	mounts []mount
+
+	// vhosts are the servers of virtual hosts by host name, sharing this
+	// server's cache. A virtual host's server has its host name in vhost,
+	// to keep its responses apart in the cache.
+	vhosts       map[string]*server
+	vhost        string
+	vhostUnknown string
+
+	// secret maps secured routes to their corresponding credentials. It is
+	// replaced as a whole when secrets are reloaded, so it is only accessed
+	// through secrets and setSecrets.
+	secret   map[string]routeSecret
+	secretMu sync.RWMutex
+
+	// corsOrigins are the origins allowed to make credentialed
+	// cross-origin requests to secured routes.
+	corsOrigins []string
+
+	// authExemptions holds patterns of paths in secured routes that are served
+	// without authentication. Patterns starting with "/" match the whole
+	// path, others match the file name.
+	authExemptions []string
+
+	// admin configures the admin endpoints. They are disabled if path is
+	// empty.
+	admin struct {
+		// path is the prefix of the admin endpoints, like "/__admin".
+		path string
+
+		// secret is the bearer token that admin requests must carry.
+		secret string
+	}
+
+	// feed is the generated feed of markdown files. If nil, there is
+	// none.
+	feed *feed
+
+	// settingsFile is the settings file the server was created from, which
+	// secrets are reloaded from.
+	settingsFile string
+
+	// authScheme is the HTTP authentication scheme for secured routes.
+	authScheme int
+
+	// nonceKey authenticates the Digest nonces issued by the server.
+	nonceKey []byte
+
+	// nonceTTL is how long a Digest nonce stays valid.
+	nonceTTL time.Duration
+
+	// dirTemplates maps directories to the markdown template that applies
+	// in them, from the nearest directory template file.
+	dirTemplates sync.Map
 
 	// mdTemplate for HTML generated from Markdown.
 	mdTemplate *template.Template
 
+	// noDirRedirect serves directory indexes without redirecting to add a
+	// trailing slash.
+	noDirRedirect bool
+
+	// fallback is a file served with 200 for any request that couldn't be
+	// resolved, taking precedence over notFound.
+	fallback string
+
+	// errorPages maps status codes to the files served as their error
+	// pages.
+	errorPages map[int]string
+
+	// wellKnown maps special root paths (e.g. "/robots.txt") to handlers
+	// that bypass authentication and path resolution.
+	wellKnown map[string]fasthttp.RequestHandler
+
+	// wellKnownDirs maps special path prefixes (e.g. "/.well-known/") to
+	// the directories whose files they serve.
+	wellKnownDirs map[string]string
+
+	// templates are alternate templates for Markdown, selected by a query
+	// flag of the same name (e.g. "?print"). templateNames are their names
+	// in order, so a request with several flags gets the first every time.
+	templates     map[string]*template.Template
+	templateNames []string
+
+	// markdown is the engine that renders markdown to HTML.
+	markdown markdownEngine
+
+	// engines are the markdown engines by name, which can be selected in
+	// a file's front matter.
+	engines map[string]markdownEngine
+
+	// renderable is the set of extensions, without the dot, that go
+	// through rendering. Files with any other extension are literal.
+	renderable map[string]bool
+
+	// asciidoctor is the command that renders AsciiDoc files, and
+	// rstCommand the one, with its arguments, that renders
+	// reStructuredText files.
+	asciidoctor string
+	rstCommand  []string
+
+	// render holds thresholds past which a markdown render is reported.
+	render struct {
+		// warnTime is the render duration to report. Zero disables it.
+		warnTime time.Duration
+
+		// warnSize is the rendered size in bytes to report. Zero disables
+		// it.
+		warnSize int
+
+		// reject fails renders that exceed a threshold.
+		reject bool
+
+		// validUTF8 replaces invalid UTF-8 in rendered output.
+		validUTF8 bool
+	}
+
+	// toc builds a table of contents for every markdown file, not only
+	// those with the marker.
+	toc bool
+
+	// detectLanguages marks code blocks without a language in markdown
+	// with the one they seem to be in.
+	detectLanguages bool
+
+	// tocJSON exposes the headings of markdown pages as JSON, either in
+	// the X-Page-TOC header or at ?toc=json, if set.
+	tocJSON string
+
+	// figures numbers figures and tables in every markdown file, not only
+	// those with the list of figures marker.
+	figures bool
+
+	// maxPathDepth is the most segments a request path may have before
+	// it is answered with 404.
+	maxPathDepth int
+
+	// maxBodySize is the largest request body accepted, in bytes. Zero
+	// means fasthttp's default.
+	maxBodySize int
+
+	// maxConnsPerIP is the most concurrent connections accepted from one
+	// client IP. Zero means unlimited.
+	maxConnsPerIP int
+
+	// timeouts bound reading requests, writing responses, and waiting
+	// for requests on idle connections. Zero means no limit.
+	timeouts struct {
+		read, write, idle time.Duration
+	}
+
+	// zip configures downloading directories as zip archives.
+	zip struct {
+		enabled bool
+
+		// maxFiles and maxBytes cap the archive. Zero means no limit.
+		maxFiles int
+		maxBytes int64
+	}
+
+	// compress enables gzip or deflate compression of text responses.
+	compress bool
+
 	// ttl is the time-to-live for the cache. If nil, no caching is done.
 	ttl   *time.Duration
 	cache *cache.Cache
 
+	// watch evicts cached responses as the files they came from change.
+	watch bool
+
+	// language is the default language of localized files. If empty,
+	// files aren't localized.
+	language string
+
+	// debug shows clients why a request failed, in place of a generic
+	// error message.
+	debug bool
+
+	// gone are the paths, or prefixes ending in a slash, of content that
+	// was permanently removed.
+	gone []string
+
+	// oddNames is how paths with trailing dots or spaces in a segment are
+	// handled, either oddNamesReject or oddNamesRedirect.
+	oddNames string
+
+	// reload has the path and secret of signed requests to flush the
+	// cache, and whether they also reload the settings. It's off without
+	// a secret.
+	reload struct {
+		path     string
+		secret   string
+		settings bool
+	}
+
+	// git keeps the served directory a working copy of a git repository,
+	// if configured.
+	git *gitContent
+
+	// health has the paths of the liveness and readiness probes, each off
+	// if empty.
+	health struct {
+		live        string
+		ready       string
+		templatesOK bool
+	}
+
+	// metrics are served at metricsPath for Prometheus, if it's set.
+	// Otherwise metrics is nil and nothing is counted.
+	metrics     *metrics
+	metricsPath string
+
+	// earlyHints are the preload links of critical assets, sent as early
+	// hints before rendering a page.
+	earlyHints []string
+
+	// linkStyle is how internal links in rendered output are rewritten,
+	// one of linkStyleClean, linkStyleIndex, or linkStyleHTML.
+	linkStyle string
+
+	// templateErrors is how templates that can't be parsed are handled,
+	// one of templateErrorsFallback, templateErrorsFail, or
+	// templateErrorsDegrade.
+	templateErrors string
+
+	// logFormat is the format of the access log, either logFormatText or
+	// logFormatJSON.
+	logFormat string
+
+	// headers are sent with every response, like security policies.
+	headers map[string]string
+
+	// fragments serves htmx requests the rendered markdown without the
+	// template.
+	fragments bool
+
+	// maxAge maps routes to the max-age in seconds of their responses,
+	// overriding the one derived from ttl.
+	maxAge map[string]int
+
+	// queryVary maps routes, or "*" for every route, to the query
+	// arguments that templates may vary on.
+	queryVary map[string][]string
+
+	// cacheSizes tracks rendered pages in the cache to keep their total
+	// size under a maximum. If nil, there is no maximum.
+	cacheSizes *cacheSizes
+
+	// extCache maps extensions, without the dot, to the caching policy of
+	// files with them, overriding ttl and maxAge.
+	extCache map[string]extCache
+
+	// cachePolicy maps routes to their caching policy. Routes marked
+	// cacheNoStore are never cached by the server or clients.
+	cachePolicy map[string]string
+
+	// delay maps path prefixes to an artificial latency in milliseconds,
+	// for testing how clients handle slow responses.
+	delay map[string]int
+
+	// cacheEntryMax is the largest rendered body in bytes that is kept in
+	// the cache. Literal files are always streamed from disk, so only their
+	// handlers are cached. Zero means no limit.
+	cacheEntryMax int
+
 	// tls maintains information for a supplementary TLS server.
 	tls struct {
 		// port is the port on which the TLS server is being hosted.
@@ -65,69 +379,97 @@ type server struct {
 		// required specifies the necessity of TLS to view resources.
 		required int
 
+		// redirectCode is the status of redirects from HTTP to HTTPS.
+		redirectCode int
+
+		// hsts is the Strict-Transport-Security header value. If empty,
+		// the header isn't sent.
+		hsts string
+
 		// cert is the file name of the certificate for the server.
 		cert string
 
 		// key is the file name of the private key for the server.
 		key string
+
+		// http3 enables an HTTP/3 server alongside the TLS server.
+		http3 bool
+
+		// acme obtains certificates automatically, in place of cert and
+		// key. If nil, they are read from the files.
+		acme *autocert.Manager
 	}
 }
 
 func (s *server) initiateCache() {
 	s.cache = cache.New(*s.ttl, time.Minute)
 	s.cache.OnEvicted(func(key string, _ interface{}) {
+		if s.cacheSizes != nil {
+			s.cacheSizes.remove(key)
+		}
 		log.Printf("removed cached item for %s", key)
 	})
+	for _, vs := range s.vhosts {
+		vs.cache, vs.cacheSizes = s.cache, s.cacheSizes
+	}
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, os.Signal(syscall.SIGUSR1))
 	go func() {
 		for {
 			<-sc
-			s.cache.Flush()
-			log.Println("received SIGUSR1, cache has been flushed")
+			s.flushCache("received SIGUSR1")
 		}
 	}()
 }
 
-// checkAuth validates a request for proper authentication, given that the
-// route requires it (i.e. the route is a key in s.Secret).
-func (s *server) checkAuth(ctx *fasthttp.RequestCtx, route string) bool {
-	h := strings.SplitN(string(ctx.Request.Header.Peek("Authorization")), " ", 2)
-	if len(h) != 2 || h[0] != "Digest" {
-		return false
+// flushCache empties the cache. Every flush goes through here so that any
+// bookkeeping kept alongside the cache is reset together with it.
+func (s *server) flushCache(reason string) {
+	if s.cache != nil && s.cacheSizes != nil {
+		// go-cache doesn't call OnEvicted for flushed items, so their
+		// sizes are forgotten all at once
+		s.cacheSizes.flush(s.cache.Flush)
+	} else if s.cache != nil {
+		s.cache.Flush()
 	}
-	digest := parseHeader(h[1])
-	realm := s.host + `-` + route
-	if digest["realm"] != realm {
-		return false
+	s.resetLookups()
+	for _, vs := range s.vhosts {
+		vs.resetLookups()
 	}
-	nonce := digest["nonce"]
-	nc := digest["nc"]
-	cnonce := digest["cnonce"]
-	qop := digest["qop"]
-	ha1b := md5.Sum([]byte(digest["username"] + ":" + realm + ":" + s.secret[route]))
-	ha2b := md5.Sum([]byte(fmt.Sprintf("%s:%s", ctx.Method(), ctx.Path())))
-	ha1 := fmt.Sprintf("%x", ha1b)
-	ha2 := fmt.Sprintf("%x", ha2b)
-	sd := strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":")
-	resb := md5.Sum([]byte(sd))
-	res := fmt.Sprintf("%x", resb)
-	return res == digest["response"]
-}
-
-// sendChallenge sends an authentication request according to the Digest
-// Access Authentication scheme per RFC 2617 using the WWW-Authenticate
-// header.
-func (s *server) sendChallenge(ctx *fasthttp.RequestCtx, route string) {
-	realm := fmt.Sprintf(`realm="%s-%s"`, s.host, route)
-	qop := `qop="auth,auth-int"`
-	nonce := fmt.Sprintf(`nonce="%x"`, time.Now())
-	challenge := strings.Join([]string{realm, qop, nonce}, ", ")
-	ctx.Response.Header.Set("WWW-Authenticate", "Digest "+challenge)
-
-	ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
-	ctx.Response.SetBodyString("Unauthorized")
-	log.Printf(logf, ctx.Method(), ctx.Path(), fasthttp.StatusUnauthorized, "Unauthorized")
+	log.Printf("%s, cache has been flushed", reason)
+}
+
+// resetLookups forgets the directory templates, commits, and image sizes
+// looked up for files, which may have changed.
+func (s *server) resetLookups() {
+	s.dirTemplates.Range(func(dir, _ interface{}) bool {
+		s.dirTemplates.Delete(dir)
+		return true
+	})
+	s.commits.Range(func(filename, _ interface{}) bool {
+		s.commits.Delete(filename)
+		return true
+	})
+	s.images.Range(func(file, _ interface{}) bool {
+		s.images.Delete(file)
+		return true
+	})
+}
+
+// watchReload reloads secrets from the settings file on SIGHUP.
+func (s *server) watchReload() {
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Signal(syscall.SIGHUP))
+	go func() {
+		for {
+			<-sc
+			if err := s.reloadSecrets(); err != nil {
+				log.Printf("received SIGHUP, secrets not reloaded: %v", err)
+			} else {
+				log.Printf("received SIGHUP, secrets have been reloaded")
+			}
+		}
+	}()
 }
 
 // serve runs the http server on the specified port.
@@ -135,62 +477,748 @@ func (s *server) serve() {
 	if s.ttl != nil {
 		s.initiateCache()
 	}
+	if s.watch {
+		watched := []*server{s}
+		for _, vs := range s.vhosts {
+			watched = append(watched, vs)
+		}
+		for _, ws := range watched {
+			if err := ws.watchContent(); err != nil {
+				log.Printf("couldn't watch %s: %v", ws.path, err)
+			}
+		}
+	}
+	if s.settingsFile != "" {
+		s.watchReload()
+	}
+	if s.git != nil && s.git.poll > 0 {
+		s.pollGit()
+	}
+	if len(s.delay) > 0 {
+		log.Println("warning: responses are delayed per 'delay', which is only meant for testing")
+	}
+	handler := s.handler()
+	srv := s.httpServer(handler)
 	if s.tls.port != "" {
+		// a server of its own, since serving TLS changes its configuration
+		// while the plain listener may already be using srv
+		tlsSrv := s.httpServer(handler)
+		if s.tls.acme != nil {
+			// certificates come from the manager instead of files
+			tlsSrv.TLSConfig = s.tls.acme.TLSConfig()
+		}
 		go func() {
-			log.Printf("starting HTTPS server on port %s", s.tls.port)
-			log.Fatal(fasthttp.ListenAndServeTLS(":"+s.tls.port, s.tls.cert, s.tls.key, s.ServeHTTP))
+			log.Printf("starting HTTPS server on %s", s.addr(s.tls.port))
+			ln, err := s.listenTCP(s.addr(s.tls.port))
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Fatal(tlsSrv.ServeTLS(ln, s.tls.cert, s.tls.key))
 		}()
+		if s.tls.http3 {
+			go s.serveHTTP3(handler)
+		}
 	}
-	if s.port != "" {
+	if s.socket != "" {
+		s.removeSocketOnExit()
 		go func() {
-			log.Printf("starting HTTP server on port %s", s.port)
-			log.Fatal(fasthttp.ListenAndServe(":"+s.port, s.ServeHTTP))
+			log.Printf("starting HTTP server on %s", s.socket)
+			// a stale socket file is removed first
+			log.Fatal(srv.ListenAndServeUNIX(s.socket, s.socketMode))
+		}()
+	} else if s.port != "" {
+		go func() {
+			log.Printf("starting HTTP server on %s", s.addr(s.port))
+			ln, err := s.listenTCP(s.addr(s.port))
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Fatal(srv.Serve(ln))
 		}()
 	}
 	// wait forever
 	<-make(chan struct{})
 }
 
-func (s *server) serveFilteredFile(ctx *fasthttp.RequestCtx, filename string) {
-	var h fasthttp.RequestHandler
-	defer func() {
-		if s.cache != nil {
-			s.cache.Set(string(ctx.Path()), h, cache.DefaultExpiration)
+// removeSocketOnExit removes the Unix domain socket when the server is
+// stopped with SIGINT or SIGTERM.
+func (s *server) removeSocketOnExit() {
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sc
+		if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+			log.Printf("received %v, couldn't remove %s: %v", sig, s.socket, err)
+			os.Exit(1)
 		}
-		h(ctx)
+		log.Printf("received %v, removed %s", sig, s.socket)
+		os.Exit(0)
 	}()
-	switch {
-	case strings.HasSuffix(filename, ".md"):
-		md, err := ioutil.ReadFile(filename)
+}
+
+// addr gives the address to listen on at a port, on the configured
+// interface or else on all of them.
+func (s *server) addr(port string) string {
+	return net.JoinHostPort(s.listen, port)
+}
+
+// httpServer creates a fasthttp server for the listeners.
+func (s *server) httpServer(handler fasthttp.RequestHandler) *fasthttp.Server {
+	return &fasthttp.Server{
+		Handler:            handler,
+		MaxRequestBodySize: s.maxBodySize,
+		MaxConnsPerIP:      s.maxConnsPerIP,
+		ReadTimeout:        s.timeouts.read,
+		WriteTimeout:       s.timeouts.write,
+		IdleTimeout:        s.timeouts.idle,
+	}
+}
+
+// handler gives the request handler shared by the listeners.
+func (s *server) handler() fasthttp.RequestHandler {
+	h := fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
+		s.checkForwarded(ctx)
+		if ctx.IsGet() || ctx.IsHead() || ctx.IsOptions() {
+			// these methods have no use for a body
+			ctx.Request.ResetBody()
+		}
+		pathStr := string(ctx.Path())
+		s.ServeHTTP(ctx)
+		// a virtual host's own secrets decide which routes are private
+		site := s
+		if vs, ok := s.vhostFor(ctx); ok {
+			site = vs
+		}
+		site.setFreshness(ctx)
+		// set last, since some responses reset the headers
+		for name, value := range s.headers {
+			ctx.Response.Header.Set(name, value)
+		}
+		if d := s.delayFor(pathStr); d > 0 {
+			time.Sleep(d)
+		}
+		s.metrics.observe(ctx.Response.StatusCode(), elapsed(ctx), responseSize(ctx))
+	})
+	if s.compress {
+		// only compresses text-like content types, and leaves responses
+		// that are already compressed alone
+		h = fasthttp.CompressHandler(h)
+	}
+	// literal files are compressed by SendFile even without compression
+	h = handlerWeakETag(h)
+	if s.logFormat == logFormatJSON {
+		h = jsonAccessLog(h)
+	}
+	return h
+}
+
+// delayFor gives the artificial latency for a path, from the longest
+// configured prefix it starts with.
+func (s *server) delayFor(pathStr string) time.Duration {
+	longest, ms := -1, 0
+	for prefix, d := range s.delay {
+		if strings.HasPrefix(pathStr, prefix) && len(prefix) > longest {
+			longest, ms = len(prefix), d
+		}
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// foreverMaxAge is the max-age in seconds for content cached forever.
+const foreverMaxAge = 365 * 24 * 60 * 60
+
+// setFreshness tells clients and proxies how long a successful response
+// stays fresh, from the max-age of the file's extension or route or else
+// the cache ttl, unless the response already says. Responses from secured
+// routes are private.
+func (s *server) setFreshness(ctx *fasthttp.RequestCtx) {
+	switch ctx.Response.StatusCode() {
+	case fasthttp.StatusOK, fasthttp.StatusPartialContent, fasthttp.StatusNotModified:
+	default:
+		return
+	}
+	if len(ctx.Response.Header.Peek("Cache-Control")) > 0 {
+		return
+	}
+	route := routeOf(string(ctx.Path()))
+	maxAge, ok := s.maxAge[route]
+	if extMaxAge, isSet := ctx.UserValue("maxAge").(int); isSet {
+		maxAge, ok = extMaxAge, true
+	}
+	if ok && maxAge < 0 {
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+		return
+	}
+	if !ok && s.ttl == nil {
+		return
+	}
+	if !ok && *s.ttl < 0 {
+		maxAge = foreverMaxAge
+	} else if !ok {
+		maxAge = int(s.ttl.Seconds())
+	}
+	cacheControl := fmt.Sprintf("max-age=%d", maxAge)
+	if _, isSecret := s.secrets()[route]; isSecret {
+		cacheControl = "private, " + cacheControl
+	}
+	ctx.Response.Header.Set("Cache-Control", cacheControl)
+	expires := time.Now().Add(time.Duration(maxAge) * time.Second)
+	ctx.Response.Header.Set("Expires", expires.UTC().Format(http.TimeFormat))
+}
+
+// templateVariant gives the name of the alternate template requested by a
+// query flag, or the empty string when the default template applies.
+func (s *server) templateVariant(ctx *fasthttp.RequestCtx) string {
+	for _, name := range s.templateNames {
+		if ctx.QueryArgs().Has(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// dirTemplateNames are the files, in order of preference, that override
+// the markdown template for a directory and its subdirectories.
+var dirTemplateNames = []string{".template", "layout.html"}
+
+// isDirTemplate reports whether a file is a directory template, which is
+// never served itself.
+func isDirTemplate(name string) bool {
+	for _, tname := range dirTemplateNames {
+		if name == tname {
+			return true
+		}
+	}
+	return false
+}
+
+// dirTemplate gives the markdown template from the nearest directory
+// template file to filename, walking up toward the served directory or
+// mount it's in, or the global template if there is none. Lookups are
+// remembered until the cache is flushed.
+func (s *server) dirTemplate(filename string) *template.Template {
+	dir := fp.Dir(filename)
+	root := s.rootOf(dir)
+	if root == "" {
+		return s.mdTemplate
+	}
+	return s.lookupDirTemplate(root, dir)
+}
+
+func (s *server) lookupDirTemplate(root, dir string) *template.Template {
+	if tpl, ok := s.dirTemplates.Load(dir); ok {
+		return tpl.(*template.Template)
+	}
+	tpl := s.mdTemplate
+	for _, name := range dirTemplateNames {
+		filename := fp.Join(dir, name)
+		if _, err := os.Stat(filename); err != nil {
+			continue
+		}
+		t, err := template.ParseFiles(filename)
 		if err != nil {
-			h = handlerInternalError(err)
-			return
+			log.Printf("couldn't load template %s: %v", filename, err)
+			if s.templateErrors == templateErrorsDegrade {
+				tpl = brokenTemplate(name, err)
+				break
+			}
+			continue
 		}
-		out := blackfriday.MarkdownCommon(md)
-		content := &templateContent{string(out)}
-		buf := new(bytes.Buffer)
-		s.mdTemplate.Execute(buf, content)
-		rd := bytes.NewReader(buf.Bytes())
-		h = handlerReader("markdown "+filename, rd)
-	case strings.HasSuffix(filename, ".jade"):
-		fallthrough
-	case strings.HasSuffix(filename, ".pug"):
-		out, err := jade.ParseFile(filename)
+		tpl = t
+		break
+	}
+	parent := fp.Dir(dir)
+	if tpl == s.mdTemplate && dir != root && parent != dir && strings.HasPrefix(parent, root) {
+		tpl = s.lookupDirTemplate(root, parent)
+	}
+	s.dirTemplates.Store(dir, tpl)
+	return tpl
+}
+
+// checkDirTemplates parses every directory template under the served
+// directory and mounts, giving the errors of those that can't be parsed.
+func (s *server) checkDirTemplates() []error {
+	var errs []error
+	for _, root := range s.roots() {
+		fp.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			for _, name := range dirTemplateNames {
+				if info.Name() != name {
+					continue
+				}
+				if _, err := template.ParseFiles(p); err != nil {
+					errs = append(errs, fmt.Errorf("couldn't load template %s: %v", p, err))
+				}
+			}
+			return nil
+		})
+	}
+	return errs
+}
+
+// cacheKey identifies the response for a request in the cache. Requests
+// for alternate templates are cached separately from the default render.
+func (s *server) cacheKey(ctx *fasthttp.RequestCtx) string {
+	key := s.vhost + string(ctx.Path())
+	if variant := s.templateVariant(ctx); variant != "" {
+		key += "?" + variant
+	}
+	if s.wantsZip(ctx) {
+		// never cached, but must not hit the cached page either
+		key += "?download=zip"
+	}
+	if s.wantsFragment(ctx) {
+		key += "?fragment"
+	}
+	if s.wantsTOCJSON(ctx) {
+		key += "?toc=json"
+	}
+	if s.language != "" && s.cache != nil {
+		// localized files are negotiated, so each language is cached
+		// separately
+		key += "?lang=" + s.cachedLanguage(ctx)
+	}
+	if query := s.templateQuery(ctx); len(query) > 0 {
+		values := make(url.Values)
+		for name, value := range query {
+			values.Set(name, value)
+		}
+		// sorted by name, and escaped so a value can't pass for more
+		// arguments
+		key += "?" + values.Encode()
+	}
+	return key
+}
+
+// wantsFragment reports whether a request from htmx gets only the
+// rendered markdown, without the template.
+func (s *server) wantsFragment(ctx *fasthttp.RequestCtx) bool {
+	return s.fragments && string(ctx.Request.Header.Peek("HX-Request")) == "true"
+}
+
+// templateQuery gives the query arguments of a request that templates see,
+// which are only those the route varies on, so that each combination is
+// cached separately.
+func (s *server) templateQuery(ctx *fasthttp.RequestCtx) map[string]string {
+	if len(s.queryVary) == 0 {
+		return nil
+	}
+	route := routeOf(string(ctx.Path()))
+	query := make(map[string]string)
+	for _, name := range append(append([]string{}, s.queryVary["*"]...), s.queryVary[route]...) {
+		if ctx.QueryArgs().Has(name) {
+			query[name] = string(ctx.QueryArgs().Peek(name))
+		}
+	}
+	return query
+}
+
+// cacheStore keeps a handler in the cache, along with the size of the
+// rendered content it holds. If the cache grows past its maximum size, the
+// least recently used rendered pages are evicted.
+func (s *server) cacheStore(key string, h fasthttp.RequestHandler, size int) {
+	s.cacheStoreFor(key, h, size, cache.DefaultExpiration)
+}
+
+// cacheStoreFor is cacheStore with the time the handler stays in the
+// cache, instead of ttl.
+func (s *server) cacheStoreFor(key string, h fasthttp.RequestHandler, size int, d time.Duration) {
+	if s.cacheSizes != nil && size > s.cacheSizes.max {
+		log.Printf("served uncached: %s (%d bytes)", key, size)
+		return
+	}
+	if s.cacheSizes == nil {
+		s.cache.Set(key, h, d)
+		return
+	}
+	evicted := s.cacheSizes.store(key, size, func() { s.cache.Set(key, h, d) })
+	for _, key := range evicted {
+		// outside the lock, since OnEvicted takes it
+		s.cache.Delete(key)
+	}
+}
+
+// extCache is the caching policy of files with an extension.
+type extCache struct {
+	// ttl is how long responses stay in the cache, or cache.NoExpiration
+	// for forever. If zero, they aren't cached.
+	ttl time.Duration
+
+	// maxAge is how long clients keep responses in seconds. If negative,
+	// they are told not to store them.
+	maxAge int
+}
+
+// extPolicy gives the handler of a file with the caching policy of its
+// extension, if it has one, along with how long the handler stays in the
+// cache and whether it is cached at all.
+func (s *server) extPolicy(filename string, h fasthttp.RequestHandler) (fasthttp.RequestHandler, time.Duration, bool) {
+	policy, ok := s.extCache[strings.TrimPrefix(fp.Ext(filename), ".")]
+	if !ok {
+		return h, cache.DefaultExpiration, true
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		// for setFreshness
+		ctx.SetUserValue("maxAge", policy.maxAge)
+		h(ctx)
+	}, policy.ttl, policy.ttl != 0
+}
+
+// cacheable reports whether handlers for the request may be kept in the
+// cache.
+func (s *server) cacheable(ctx *fasthttp.RequestCtx) bool {
+	return s.cache != nil && s.cachePolicy[routeOf(string(ctx.Path()))] != cacheNoStore
+}
+
+// pathDepth gives the number of segments in a request path.
+func pathDepth(pathStr string) int {
+	trimmed := strings.Trim(pathStr, "/")
+	if trimmed == "" {
+		return 0
+	}
+	return strings.Count(trimmed, "/") + 1
+}
+
+// hiddenPath reports whether a request path has a hidden segment, like
+// the .git of a working copy or a settings file kept beside the content.
+// Only /.well-known/ is exempt, since clients look for it by name.
+func hiddenPath(pathStr string) bool {
+	for i, segment := range strings.Split(pathStr, "/") {
+		if i == 1 && segment == ".well-known" || segment == "." || segment == ".." {
+			continue
+		}
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// trimOddNames strips the trailing dots and spaces of each segment of a
+// request path, which some filesystems ignore and which confuse extension
+// matching. It also reports whether there were any, and whether a segment
+// is left empty.
+func trimOddNames(pathStr string) (trimmed string, odd bool, empty bool) {
+	segments := strings.Split(pathStr, "/")
+	for i, segment := range segments {
+		t := strings.TrimRight(segment, ". ")
+		if t == segment {
+			continue
+		}
+		odd = true
+		if t == "" {
+			empty = true
+		}
+		segments[i] = t
+	}
+	return strings.Join(segments, "/"), odd, empty
+}
+
+// isGone reports whether a request path was permanently removed, either
+// listed as is or under a listed prefix ending in a slash.
+func (s *server) isGone(pathStr string) bool {
+	for _, p := range s.gone {
+		if pathStr == p || strings.HasSuffix(p, "/") && strings.HasPrefix(pathStr, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// noCache reports whether the client asked for a fresh response, as when
+// reloading a page. The fresh response still replaces the cached one.
+func noCache(ctx *fasthttp.RequestCtx) bool {
+	for _, directive := range strings.Split(string(ctx.Request.Header.Peek("Cache-Control")), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return strings.EqualFold(string(ctx.Request.Header.Peek("Pragma")), "no-cache")
+}
+
+// routeOf gives the route of a request path, its top-level directory.
+func routeOf(pathStr string) string {
+	splits := strings.SplitN(pathStr, "/", 3)
+	if len(splits) < 2 {
+		return ""
+	}
+	return splits[1]
+}
+
+func (s *server) serveFilteredFile(ctx *fasthttp.RequestCtx, filename string) {
+	ext := strings.TrimPrefix(fp.Ext(filename), ".")
+	if _, isPage := pageKinds[ext]; isPage && s.renderable[ext] {
+		// the client can fetch assets while the page renders
+		s.sendEarlyHints(ctx)
+	}
+	h, size, err := s.fileHandler(ctx, filename)
+	if err != nil {
+		if rerr, ok := err.(*RenderError); ok {
+			s.metrics.renderError(rerr.Stage)
+		}
+		h = s.errorHandler(ctx, fasthttp.StatusInternalServerError, err)
+	}
+	h, ttl, store := s.extPolicy(filename, h)
+	if s.cacheable(ctx) && store && s.cacheEntryMax > 0 && size > s.cacheEntryMax {
+		log.Printf("served uncached: %s (%d bytes)", s.cacheKey(ctx), size)
+	} else if s.cacheable(ctx) && store {
+		s.cacheStoreFor(s.cacheKey(ctx), h, size, ttl)
+	}
+	h(ctx)
+}
+
+// Stages of rendering a file, at which it can fail.
+const (
+	stageRead     = "read"
+	stageParse    = "parse"
+	stageTemplate = "template"
+	stageLimit    = "limit"
+)
+
+// RenderError is a failure to render a file, with the stage that failed.
+type RenderError struct {
+	Path  string
+	Stage string
+	Err   error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("%s of %s failed: %v", e.Stage, e.Path, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// fileHandler creates the handler for a file, rendering it if necessary.
+// The size of the rendered content held by the handler, if any, is also
+// returned.
+func (s *server) fileHandler(ctx *fasthttp.RequestCtx, filename string) (h fasthttp.RequestHandler, size int, err error) {
+	ext := strings.TrimPrefix(fp.Ext(filename), ".")
+	if !s.renderable[ext] {
+		// anything not allowed to render is served as is
+		ext = ""
+	}
+	switch ext {
+	case "md", "markdown", "adoc", "asciidoc", "rst", "jade", "pug":
+		out, err := s.renderFile(ctx, filename, ext)
 		if err != nil {
-			h = handlerInternalError(err)
-			return
+			return nil, 0, err
 		}
-		rd := bytes.NewReader([]byte(out))
-		h = handlerReader("pug "+filename, rd)
-	case strings.HasSuffix(filename, ".redirect"):
+		size = len(out)
+		rd := bytes.NewReader(out)
+		h = handlerReader(pageKinds[ext]+" "+filename, rd)
+		if headings, ok := ctx.UserValue("headings").([]tocEntry); ok {
+			switch {
+			case s.wantsTOCJSON(ctx):
+				h = handlerTOCJSON(filename, tocJSON(headings))
+			case s.tocJSON == tocJSONHeader:
+				h = handlerHeader("X-Page-TOC", base64.StdEncoding.EncodeToString(tocJSON(headings)), h)
+			}
+		}
+	case "redirect":
 		url, err := ioutil.ReadFile(filename)
 		if err != nil {
-			h = handlerInternalError(err)
+			return nil, 0, &RenderError{filename, stageRead, err}
 		}
 		h = handlerRedirect(string(url))
 	default:
 		h = handlerLiteralFile(filename)
 	}
+	return
+}
+
+// pageKinds maps the extensions of pages, which render to HTML, to the
+// kind of page they are.
+var pageKinds = map[string]string{
+	"md":       "markdown",
+	"markdown": "markdown",
+	"adoc":     "asciidoc",
+	"asciidoc": "asciidoc",
+	"rst":      "rst",
+	"jade":     "pug",
+	"pug":      "pug",
+}
+
+// renderFile renders a page to HTML as it is served, putting it into its
+// template unless it's pug.
+func (s *server) renderFile(ctx *fasthttp.RequestCtx, filename, ext string) ([]byte, error) {
+	switch ext {
+	case "md", "markdown":
+		md, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, &RenderError{filename, stageRead, err}
+		}
+		start := time.Now()
+		out, meta := s.renderMarkdown(filename, md)
+		if s.imageSizes {
+			out = s.addImageSizes(filename, out)
+		}
+		if s.detectLanguages {
+			out = detectLanguages(out)
+		}
+		var toc string
+		if wants := s.wantsTOC(md, meta); wants || s.tocJSON != "" {
+			var entries []tocEntry
+			out, entries = buildTOC(out, s.slug)
+			ctx.SetUserValue("headings", entries)
+			if wants {
+				toc = tocHTML(entries)
+				out = insertTOC(out, tocMarker, toc)
+			}
+		}
+		var lof string
+		if s.wantsFigures(md, meta) {
+			var entries []figureEntry
+			out, entries = numberFigures(out)
+			lof = lofHTML(entries)
+			out = insertTOC(out, lofMarker, lof)
+		}
+		content := &templateContent{
+			Content: string(out),
+			Title:   markdownTitle(filename, meta, out),
+			Meta:    meta,
+			TOC:     toc,
+			Figures: lof,
+		}
+		return s.renderPage(ctx, filename, content, start)
+	case "adoc", "asciidoc", "rst":
+		start := time.Now()
+		render := s.renderAsciidoc
+		if ext == "rst" {
+			render = s.renderRST
+		}
+		out, err := render(filename)
+		if err != nil {
+			return nil, &RenderError{filename, stageParse, err}
+		}
+		content := &templateContent{
+			Content: string(out),
+			Title:   markdownTitle(filename, nil, out),
+		}
+		return s.renderPage(ctx, filename, content, start)
+	case "jade", "pug":
+		out, err := jade.ParseFile(filename)
+		if err != nil {
+			return nil, &RenderError{filename, stageParse, err}
+		}
+		return s.checkUTF8(filename, s.rewriteLinks([]byte(out))), nil
+	}
+	return nil, fmt.Errorf("%s isn't a page", filename)
+}
+
+// renderPage puts rendered content into the template for a file, or gives
+// it alone for fragment requests, checking how long rendering took since
+// start and what it produced.
+func (s *server) renderPage(ctx *fasthttp.RequestCtx, filename string, content *templateContent, start time.Time) ([]byte, error) {
+	content.Query = s.templateQuery(ctx)
+	if base, ok := ctx.UserValue("base").(string); ok {
+		content.Base = base
+	}
+	if lang, ok := ctx.UserValue("lang").(string); ok {
+		content.Lang = lang
+	}
+	if s.lastCommit {
+		content.LastCommit = s.commitOf(filename)
+	}
+	buf := new(bytes.Buffer)
+	if s.wantsFragment(ctx) {
+		// just the content, for swapping into a page
+		buf.WriteString(content.Content)
+	} else {
+		tpl := s.dirTemplate(filename)
+		if variant := s.templateVariant(ctx); variant != "" {
+			tpl = s.templates[variant]
+		}
+		if err := tpl.Execute(buf, content); err != nil {
+			return nil, &RenderError{filename, stageTemplate, err}
+		}
+	}
+	if err := s.checkRender(filename, time.Since(start), buf.Len()); err != nil {
+		return nil, &RenderError{filename, stageLimit, err}
+	}
+	return s.checkUTF8(filename, s.rewriteLinks(buf.Bytes())), nil
+}
+
+// notFoundHandler creates the handler for a request that couldn't be
+// resolved. A configured fallback page is served with 200, otherwise the
+// 404 error page.
+func (s *server) notFoundHandler(ctx *fasthttp.RequestCtx) fasthttp.RequestHandler {
+	if s.fallback != "" {
+		h, _, err := s.fileHandler(ctx, s.fallback)
+		if err == nil {
+			return h
+		}
+		log.Printf("couldn't serve fallback page %s: %v", s.fallback, err)
+	}
+	return s.errorHandler(ctx, fasthttp.StatusNotFound, nil)
+}
+
+// errorHandler creates the handler for an error response, rendering the
+// configured error page for the status code. Without one, or if it can't
+// be served, the plaintext response is used.
+func (s *server) errorHandler(ctx *fasthttp.RequestCtx, code int, err error) fasthttp.RequestHandler {
+	fallback := handlerNotFound()
+	switch code {
+	case fasthttp.StatusInternalServerError:
+		fallback = handlerInternalError(err, s.debug)
+	case fasthttp.StatusGone:
+		fallback = handlerGone()
+	}
+	filename, ok := s.errorPages[code]
+	if !ok {
+		return fallback
+	}
+	if _, statErr := os.Stat(filename); statErr != nil {
+		log.Printf("couldn't serve %d page %s: %v", code, filename, statErr)
+		return fallback
+	}
+	h, _, pageErr := s.fileHandler(ctx, filename)
+	if pageErr != nil {
+		log.Printf("couldn't serve %d page %s: %v", code, filename, pageErr)
+		return fallback
+	}
+	h = handlerStatus(code, h)
+	if err == nil {
+		return h
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		logRequest(ctx, code, err.Error())
+		h(ctx)
+	}
+}
+
+// checkUTF8 replaces invalid UTF-8 in rendered output with U+FFFD if
+// configured, so every browser displays it the same way.
+func (s *server) checkUTF8(filename string, out []byte) []byte {
+	if !s.render.validUTF8 || utf8.Valid(out) {
+		return out
+	}
+	log.Printf("replaced invalid UTF-8 in render of %s", filename)
+	return bytes.ToValidUTF8(out, []byte("\uFFFD"))
+}
+
+// checkRender logs a markdown render that exceeded the configured time or
+// size thresholds. An error is returned if such renders are rejected.
+func (s *server) checkRender(filename string, elapsed time.Duration, size int) error {
+	var exceeded []string
+	if s.render.warnTime > 0 && elapsed > s.render.warnTime {
+		exceeded = append(exceeded, fmt.Sprintf("took %s", elapsed))
+	}
+	if s.render.warnSize > 0 && size > s.render.warnSize {
+		exceeded = append(exceeded, fmt.Sprintf("produced %d bytes", size))
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("markdown render of %s %s", filename, strings.Join(exceeded, " and "))
+	log.Println(msg)
+	if s.render.reject {
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
 }
 
 // ServeHTTP handles requests. It first authenticates using Digest Access
@@ -198,55 +1226,169 @@ func (s *server) serveFilteredFile(ctx *fasthttp.RequestCtx, filename string) {
 // first, followed by files matching an implicit extension, and finally
 // a directory index if applicable.
 func (s *server) ServeHTTP(ctx *fasthttp.RequestCtx) {
-	if s.tls.port != "" {
-		ctx.Response.Header.Add("Strict-Transport-Security", "max-age=63072000")
+	ctx.SetUserValue("start", time.Now())
+	if s.serveACMEChallenge(ctx) {
+		return
+	}
+	if s.tls.hsts != "" {
+		ctx.Response.Header.Add("Strict-Transport-Security", s.tls.hsts)
+	}
+	if s.tls.http3 && isTLS(ctx) {
+		ctx.Response.Header.Set("Alt-Svc", s.altSvc())
+	}
+	if s.serveWellKnown(ctx) {
+		return
+	}
+	if s.serveHealth(ctx) {
+		return
 	}
 	if s.checkTLSRedirect(ctx, requiredAll) {
 		return
 	}
+	if s.serveAdmin(ctx) {
+		return
+	}
+	if s.serveGitWebhook(ctx) {
+		return
+	}
+	if s.serveReload(ctx) {
+		return
+	}
+	if s.serveMetrics(ctx) {
+		return
+	}
+	if s.vhosts != nil {
+		vs, ok := s.vhostFor(ctx)
+		if !ok {
+			handlerNotFound()(ctx)
+			return
+		}
+		vs.serveSite(ctx)
+		return
+	}
+	s.serveSite(ctx)
+}
+
+// serveSite answers a request with the content of the site.
+func (s *server) serveSite(ctx *fasthttp.RequestCtx) {
+	if s.serveFeed(ctx) {
+		return
+	}
 
 	pathStr := string(ctx.Path())
+	if pathDepth(pathStr) > s.maxPathDepth {
+		// reject before touching the filesystem
+		handlerNotFound()(ctx)
+		return
+	}
+	if trimmed, odd, empty := trimOddNames(pathStr); odd {
+		if s.oddNames == oddNamesRedirect && !empty {
+			ctx.Redirect(trimmed, fasthttp.StatusMovedPermanently)
+			logRequest(ctx, fasthttp.StatusMovedPermanently, "")
+			return
+		}
+		handlerNotFound()(ctx)
+		return
+	}
+	if hiddenPath(pathStr) {
+		// never resolved, so nothing hidden is given away
+		handlerNotFound()(ctx)
+		return
+	}
+	if s.isGone(pathStr) {
+		s.errorHandler(ctx, fasthttp.StatusGone, nil)(ctx)
+		return
+	}
 	if len(pathStr) > 1 {
 		splits := strings.Split(pathStr, "/")
 		if len(splits) > 1 {
 			route := splits[1]
-			_, isSecret := s.secret[route]
+			_, isSecret := s.secrets()[route]
 			if isSecret {
 				if s.checkTLSRedirect(ctx, requiredSecrets) {
 					return
 				}
-				ok := s.checkAuth(ctx, route)
-				if !ok {
-					s.sendChallenge(ctx, route)
+				if ctx.IsOptions() {
+					// preflight requests can't carry credentials
+					s.sendPreflight(ctx)
 					return
 				}
+				s.setCORS(ctx)
+				if !s.authExempt(pathStr) {
+					ok, stale := s.checkAuth(ctx, route)
+					if !ok {
+						s.sendChallenge(ctx, route, stale)
+						return
+					}
+				}
 			}
 		}
 	}
 
-	if s.cache != nil {
-		h, ok := s.cache.Get(pathStr)
+	if s.fragments {
+		ctx.Response.Header.Add("Vary", "HX-Request")
+	}
+	if s.language != "" {
+		ctx.Response.Header.Add("Vary", "Accept-Language")
+	}
+	if s.cachePolicy[routeOf(pathStr)] == cacheNoStore {
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+	}
+
+	key := s.cacheKey(ctx)
+	if s.cacheable(ctx) && !noCache(ctx) {
+		h, ok := s.cache.Get(key)
+		s.metrics.cacheLookup(ok)
+		if ok && s.cacheSizes != nil {
+			s.cacheSizes.touch(key)
+		}
 		if ok {
-			log.Printf("found in cache: %s", pathStr)
+			log.Printf("found in cache: %s", key)
 			h.(fasthttp.RequestHandler)(ctx)
 			return
 		}
 	}
 
-	path := fp.Join(s.path, pathStr)
+	if s.singleFile {
+		// dir is a file, which answers every request
+		s.serveFilteredFile(ctx, s.path)
+		return
+	}
+
+	root, path := s.resolve(pathStr)
+	// rewritten links lead to the rendered page here too, unless there's
+	// a file by that name
+	styled := false
+	if clean, ok := s.unstyleLink(pathStr); ok {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			pathStr, styled = clean, true
+			root, path = s.resolve(pathStr)
+		}
+	}
 
 	// follow symbolic links
 	link, err := os.Readlink(path)
-	if err == nil {
+	if err == nil && !s.noFollowSymlinks {
+		if !fp.IsAbs(link) {
+			link = fp.Join(fp.Dir(path), link)
+		}
 		path = link
 	}
+	if !s.contained(root, path) {
+		s.serveNotFound(ctx, key)
+		return
+	}
 
 	// serve literal files
 	fi, err := os.Stat(path)
 	if err == nil && !fi.IsDir() {
-		h := handlerLiteralFile(path)
-		if s.cache != nil {
-			s.cache.Set(pathStr, h, cache.DefaultExpiration)
+		if isDirTemplate(fp.Base(path)) {
+			s.serveNotFound(ctx, key)
+			return
+		}
+		h, ttl, store := s.extPolicy(path, handlerLiteralFile(path))
+		if s.cacheable(ctx) && store {
+			s.cacheStoreFor(key, h, 0, ttl)
 		}
 		h(ctx)
 		return
@@ -254,53 +1396,47 @@ func (s *server) ServeHTTP(ctx *fasthttp.RequestCtx) {
 
 	files, err := ioutil.ReadDir(fp.Dir(path))
 	if err != nil {
-		h := handlerNotFound()
-		if s.cache != nil {
-			s.cache.Set(pathStr, h, cache.DefaultExpiration)
-		}
-		h(ctx)
+		s.serveNotFound(ctx, key)
 		return
 	}
 
 	// find first file matching name.*
-	filtered := ""
-	name := fp.Base(path)
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		ext := fp.Ext(file.Name())
-		pref := strings.TrimSuffix(file.Name(), ext)
-		if pref == name {
-			filtered = file.Name()
-			break
-		}
-	}
+	filtered, lang, langs := s.matchName(ctx, files, fp.Base(path))
 	if filtered != "" {
 		// matching file found
 		filename := fp.Join(fp.Dir(path), filtered)
+		if !s.contained(root, filename) || styled && !s.rendered(fp.Ext(filtered)) {
+			s.serveNotFound(ctx, key)
+			return
+		}
+		s.rememberLanguages(ctx, langs)
+		ctx.SetUserValue("lang", lang)
 		s.serveFilteredFile(ctx, filename)
 		return
 	}
 
 	fi, err = os.Stat(path)
 	if err != nil {
-		h := handlerNotFound()
-		if s.cache != nil {
-			s.cache.Set(pathStr, h, cache.DefaultExpiration)
-		}
-		h(ctx)
+		s.serveNotFound(ctx, key)
+		return
+	}
+
+	if s.wantsZip(ctx) {
+		s.serveZip(ctx, path)
 		return
 	}
 
-	// directory requested, force trailing "/"
-	if !strings.HasSuffix(pathStr, "/") {
+	// directory requested, force trailing "/" unless disabled, in which case
+	// the index is told its base so relative links still resolve
+	if !strings.HasSuffix(pathStr, "/") && s.noDirRedirect {
+		ctx.SetUserValue("base", pathStr+"/")
+	} else if !strings.HasSuffix(pathStr, "/") {
 		h := fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
 			ctx.Redirect(pathStr+"/", fasthttp.StatusMovedPermanently)
-			log.Printf(logf, ctx.Method(), pathStr, fasthttp.StatusMovedPermanently, "")
+			logRequest(ctx, fasthttp.StatusMovedPermanently, "")
 		})
-		if s.cache != nil {
-			s.cache.Set(pathStr, h, cache.DefaultExpiration)
+		if s.cacheable(ctx) {
+			s.cacheStore(key, h, 0)
 		}
 		h(ctx)
 		return
@@ -308,37 +1444,99 @@ func (s *server) ServeHTTP(ctx *fasthttp.RequestCtx) {
 
 	// serve directory index
 	files, _ = ioutil.ReadDir(path)
-	// find first file matching index.*
-	filtered = ""
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		ext := fp.Ext(file.Name())
-		pref := strings.TrimSuffix(file.Name(), ext)
-		if pref == "index" {
-			filtered = file.Name()
+	// find first file matching one of the index names
+	for _, name := range s.indexNames {
+		if filtered, lang, langs = s.matchName(ctx, files, name); filtered != "" {
 			break
 		}
 	}
 	if filtered != "" {
 		// matching file found
 		filename := fp.Join(path, filtered)
+		if !s.contained(root, filename) || styled && !s.rendered(fp.Ext(filtered)) {
+			s.serveNotFound(ctx, key)
+			return
+		}
+		s.rememberLanguages(ctx, langs)
+		ctx.SetUserValue("lang", lang)
 		s.serveFilteredFile(ctx, filename)
 		return
 	}
 
-	h := handlerNotFound()
-	if s.cache != nil {
-		s.cache.Set(pathStr, h, cache.DefaultExpiration)
+	dirPath := strings.TrimSuffix(pathStr, "/") + "/"
+	if target, ok := s.dirDefaults[dirPath]; ok {
+		h := fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
+			ctx.Redirect(target, fasthttp.StatusFound)
+			logRequest(ctx, fasthttp.StatusFound, "")
+		})
+		if s.cacheable(ctx) {
+			s.cacheStore(key, h, 0)
+		}
+		h(ctx)
+		return
+	}
+
+	if s.autoindex {
+		h, size, err := s.autoindexHandler(ctx, path)
+		if err != nil {
+			h = s.errorHandler(ctx, fasthttp.StatusInternalServerError, err)
+		}
+		if s.cacheable(ctx) {
+			s.cacheStore(key, h, size)
+		}
+		h(ctx)
+		return
+	}
+
+	s.serveNotFound(ctx, key)
+}
+
+// serveNotFound answers a request that couldn't be resolved, caching the
+// answer.
+func (s *server) serveNotFound(ctx *fasthttp.RequestCtx, key string) {
+	h := s.notFoundHandler(ctx)
+	if s.cacheable(ctx) {
+		s.cacheStore(key, h, 0)
 	}
 	h(ctx)
 }
 
+// serveWellKnown serves configured special root paths, which are reachable
+// over plain HTTP (as ACME requires) and without authentication. It reports
+// whether the request was handled.
+func (s *server) serveWellKnown(ctx *fasthttp.RequestCtx) bool {
+	pathStr := string(ctx.Path())
+	if h, ok := s.wellKnown[pathStr]; ok {
+		h(ctx)
+		return true
+	}
+	for prefix, dir := range s.wellKnownDirs {
+		if !strings.HasPrefix(pathStr, prefix) {
+			continue
+		}
+		filename := fp.Join(dir, fp.FromSlash(path.Clean("/"+strings.TrimPrefix(pathStr, prefix))))
+		fi, err := os.Stat(filename)
+		if err != nil || fi.IsDir() {
+			handlerNotFound()(ctx)
+			return true
+		}
+		handlerMaxAge(wellKnownMaxAge, handlerLiteralFile(filename))(ctx)
+		return true
+	}
+	return false
+}
+
+// isTLS reports whether a request arrived over TLS, including requests
+// adapted from the HTTP/3 server.
+func isTLS(ctx *fasthttp.RequestCtx) bool {
+	secure, _ := ctx.UserValue("tls").(bool)
+	return secure || ctx.IsTLS()
+}
+
 func (s *server) checkTLSRedirect(ctx *fasthttp.RequestCtx, cond int) bool {
-	if s.tls.required != cond || ctx.IsTLS() {
+	if s.tls.required != cond || isTLS(ctx) {
 		return false
 	}
-	ctx.Redirect(fmt.Sprintf("https://%s%s", s.host, ctx.Path()), fasthttp.StatusSeeOther)
+	ctx.Redirect(fmt.Sprintf("https://%s%s", s.host, ctx.Path()), s.tls.redirectCode)
 	return true
 }
@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tlsSettings enables TLS with certificate files, which only need to exist
+// for a server that isn't started.
+const tlsSettings = "host: example.com\ntls:\n  cert: cert.pem\n  privkey: key.pem\n"
+
+var tlsFiles = map[string]string{"cert.pem": "", "key.pem": "", "page.md": "page"}
+
+func TestTLSRedirectCode(t *testing.T) {
+	for _, code := range []int{301, 302, 303, 307, 308} {
+		s := newTestServer(t, tlsSettings+"  required: all\n  redirect_code: "+strconv.Itoa(code)+"\n", tlsFiles)
+		resp := get(s, "http://example.com/page")
+		if resp.StatusCode() != code {
+			t.Errorf("redirect_code %d: got %d", code, resp.StatusCode())
+		}
+		if loc := string(resp.Header.Peek("Location")); loc != "https://example.com/page" {
+			t.Errorf("redirect_code %d: got Location %q", code, loc)
+		}
+	}
+	s := newTestServer(t, tlsSettings+"  required: all\n", tlsFiles)
+	if resp := get(s, "http://example.com/page"); resp.StatusCode() != 303 {
+		t.Errorf("default: got %d, want 303", resp.StatusCode())
+	}
+	for _, code := range []string{"200", "304", "404"} {
+		if exited, out := toServerExits(t, tlsSettings+"  redirect_code: "+code+"\n", tlsFiles); !exited || !strings.Contains(out, "tls.redirect_code") {
+			t.Errorf("redirect_code %s: exited %v with %q", code, exited, out)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings string
+		want     string
+	}{
+		{"default", "", "max-age=63072000"},
+		{"disabled", "  hsts:\n    disabled: true\n", ""},
+		{"max_age", "  hsts:\n    max_age: 600\n", "max-age=600"},
+		{"include_subdomains and preload", "  hsts:\n    include_subdomains: true\n    preload: true\n", "max-age=63072000; includeSubDomains; preload"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, tlsSettings+tt.settings, tlsFiles)
+		if got := string(get(s, "/page").Header.Peek("Strict-Transport-Security")); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// no header without TLS
+	s := newTestServer(t, "", map[string]string{"page.md": "page"})
+	if got := string(get(s, "/page").Header.Peek("Strict-Transport-Security")); got != "" {
+		t.Errorf("without TLS: got %q", got)
+	}
+}
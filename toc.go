@@ -0,0 +1,317 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/valyala/fasthttp"
+)
+
+// tocMarker is replaced by the table of contents where it appears in a
+// markdown document.
+const tocMarker = "[[TOC]]"
+
+var (
+	headingPattern = regexp.MustCompile(`(?s)<h([1-3])([^>]*)>(.*?)</h[1-3]>`)
+	idPattern      = regexp.MustCompile(`\bid="([^"]*)"`)
+	tagPattern     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// tocEntry is a heading listed in a table of contents.
+type tocEntry struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// Ways of exposing the headings of markdown pages as JSON.
+const (
+	tocJSONHeader = "header" // base64 in the X-Page-TOC header
+	tocJSONQuery  = "query"  // the response to ?toc=json
+)
+
+// tocNode is a heading in the tree of a page's headings, with the headings
+// under it.
+type tocNode struct {
+	Level    int        `json:"level"`
+	ID       string     `json:"id"`
+	Text     string     `json:"text"`
+	Children []*tocNode `json:"children,omitempty"`
+}
+
+// wantsTOCJSON reports whether a request asks for the headings of a
+// markdown page as JSON instead of the page.
+func (s *server) wantsTOCJSON(ctx *fasthttp.RequestCtx) bool {
+	return s.tocJSON == tocJSONQuery && string(ctx.QueryArgs().Peek("toc")) == "json"
+}
+
+// tocJSON gives headings as a JSON tree, each under the nearest heading
+// before it of a higher level.
+func tocJSON(entries []tocEntry) []byte {
+	roots := []*tocNode{}
+	var open []*tocNode
+	for _, e := range entries {
+		n := &tocNode{Level: e.Level, ID: e.ID, Text: e.Text}
+		for len(open) > 0 && open[len(open)-1].Level >= e.Level {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			roots = append(roots, n)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, n)
+		}
+		open = append(open, n)
+	}
+	out, _ := json.Marshal(roots)
+	return out
+}
+
+// handlerTOCJSON responds with the headings of a page as JSON.
+func handlerTOCJSON(filename string, body []byte) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.SetContentType("application/json")
+		ctx.SetBody(body)
+		logRequest(ctx, fasthttp.StatusOK, "toc "+filename)
+	}
+}
+
+// wantsTOC reports whether a table of contents is built for markdown
+// source, either for every file, by "toc: true" in its front matter, or by
+// the marker.
+func (s *server) wantsTOC(md []byte, meta map[string]interface{}) bool {
+	return s.toc || meta["toc"] == true || bytes.Contains(md, []byte(tocMarker))
+}
+
+// buildTOC gives rendered HTML with an id on every <h1>–<h3> heading,
+// along with the headings in order. Headings that already have an id keep
+// it, and others get a slug of their text, deduplicated with a numeric
+// suffix.
+func buildTOC(out []byte, sl slugger) ([]byte, []tocEntry) {
+	var entries []tocEntry
+	used := make(map[string]int)
+	out = headingPattern.ReplaceAllFunc(out, func(h []byte) []byte {
+		m := headingPattern.FindSubmatch(h)
+		level, _ := strconv.Atoi(string(m[1]))
+		attrs, inner := string(m[2]), string(m[3])
+		text := headingText(inner)
+		var id string
+		if idm := idPattern.FindStringSubmatch(attrs); idm != nil {
+			id = html.UnescapeString(idm[1])
+			used[id]++
+		} else {
+			slug := sl.slugify(text)
+			id = slug
+			// a suffixed slug may itself be taken, by a heading like "a 1"
+			for n := used[slug]; used[id] > 0; n++ {
+				id = fmt.Sprintf("%s%s%d", slug, sl.sep(), n)
+			}
+			if id != slug {
+				used[slug]++
+			}
+			used[id]++
+			// kept punctuation may include quotes and brackets
+			attrs = fmt.Sprintf(` id="%s"`, html.EscapeString(id)) + attrs
+		}
+		entries = append(entries, tocEntry{level, id, text})
+		return []byte(fmt.Sprintf("<h%d%s>%s</h%d>", level, attrs, inner, level))
+	})
+	return out, entries
+}
+
+// tocHTML gives a table of contents as a list of links, with each item's
+// class naming its heading level.
+func tocHTML(entries []tocEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<ul class="toc">` + "\n")
+	for _, e := range entries {
+		fmt.Fprintf(buf, "<li class=\"toc-h%d\"><a href=\"#%s\">%s</a></li>\n",
+			e.Level, html.EscapeString(e.ID), html.EscapeString(e.Text))
+	}
+	buf.WriteString("</ul>\n")
+	return buf.String()
+}
+
+// codeSpanPattern matches code in rendered HTML, where markers are left
+// alone.
+var codeSpanPattern = regexp.MustCompile(`(?s)<pre\b.*?</pre>|<code\b.*?</code>`)
+
+// insertTOC replaces the first marker outside of code, like that of the
+// table of contents, in rendered HTML. A marker alone in a paragraph
+// replaces the paragraph.
+func insertTOC(out []byte, marker, toc string) []byte {
+	code := codeSpanPattern.FindAllIndex(out, -1)
+	for start := 0; ; {
+		i := bytes.Index(out[start:], []byte(marker))
+		if i < 0 {
+			return out
+		}
+		i += start
+		inCode := false
+		for _, span := range code {
+			inCode = inCode || span[0] <= i && i < span[1]
+		}
+		if inCode {
+			start = i + len(marker)
+			continue
+		}
+		end := i + len(marker)
+		if para := "<p>" + marker + "</p>\n"; i >= 3 && bytes.HasPrefix(out[i-3:], []byte(para)) {
+			i, end = i-3, i-3+len(para)
+		}
+		return append(append(append([]byte{}, out[:i]...), toc...), out[end:]...)
+	}
+}
+
+// headingText gives the plain text of a heading's inner HTML.
+func headingText(inner string) string {
+	return strings.TrimSpace(html.UnescapeString(tagPattern.ReplaceAllString(inner, "")))
+}
+
+// slugger makes the anchors of headings. The zero value makes GitHub-style
+// slugs: letters and digits, lowercased, with runs of anything else turned
+// into single dashes.
+type slugger struct {
+	preserveCase bool
+	separator    string // a dash if empty
+	keepPunct    bool   // only whitespace is replaced
+}
+
+func (sl slugger) sep() string {
+	if sl.separator == "" {
+		return "-"
+	}
+	return sl.separator
+}
+
+// slugify gives a heading's anchor.
+func (sl slugger) slugify(text string) string {
+	sep := sl.sep()
+	if !sl.preserveCase {
+		text = strings.ToLower(text)
+	}
+	var b strings.Builder
+	pending := false
+	for _, r := range text {
+		keep := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if sl.keepPunct {
+			keep = !unicode.IsSpace(r)
+		}
+		if !keep {
+			pending = true
+			continue
+		}
+		if pending && b.Len() > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(r)
+		pending = false
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
+
+// lofMarker is replaced by the list of figures and tables where it
+// appears in a markdown document.
+const lofMarker = "[LOF]"
+
+var (
+	figurePattern = regexp.MustCompile(`<p><img([^>]*?)\s*/?></p>`)
+	tablePattern  = regexp.MustCompile(`<table([^>]*)>`)
+	altPattern    = regexp.MustCompile(`\balt="([^"]*)"`)
+)
+
+// figureEntry is a figure or table listed in a list of figures.
+type figureEntry struct {
+	Kind   string // "Figure" or "Table"
+	Number int
+	ID     string
+	Text   string
+}
+
+// wantsFigures reports whether figures and tables are numbered for
+// markdown source, either for every file, by "figures: true" in its front
+// matter, or by the marker.
+func (s *server) wantsFigures(md []byte, meta map[string]interface{}) bool {
+	return s.figures || meta["figures"] == true || bytes.Contains(md, []byte(lofMarker))
+}
+
+// numberFigures gives rendered HTML with images standing alone in a
+// paragraph turned into numbered figures, captioned by their alt text, and
+// with numbered captions on tables. Figures and tables are numbered
+// separately, in document order, along with the list of them.
+func numberFigures(out []byte) ([]byte, []figureEntry) {
+	var entries []figureEntry
+	figures, tables := 0, 0
+	out = figurePattern.ReplaceAllFunc(out, func(p []byte) []byte {
+		attrs := string(figurePattern.FindSubmatch(p)[1])
+		figures++
+		e := figureEntry{Kind: "Figure", Number: figures, ID: fmt.Sprintf("figure-%d", figures)}
+		if m := altPattern.FindStringSubmatch(attrs); m != nil {
+			e.Text = html.UnescapeString(m[1])
+		}
+		entries = append(entries, e)
+		return []byte(fmt.Sprintf("<figure id=\"%s\"><img%s />\n<figcaption>%s</figcaption></figure>",
+			e.ID, attrs, html.EscapeString(e.caption())))
+	})
+	out = tablePattern.ReplaceAllFunc(out, func(t []byte) []byte {
+		attrs := string(tablePattern.FindSubmatch(t)[1])
+		tables++
+		e := figureEntry{Kind: "Table", Number: tables, ID: fmt.Sprintf("table-%d", tables)}
+		entries = append(entries, e)
+		return []byte(fmt.Sprintf("<table id=\"%s\"%s>\n<caption>%s</caption>", e.ID, attrs, e.caption()))
+	})
+	return out, entries
+}
+
+// caption gives the numbered caption of a figure or table.
+func (e figureEntry) caption() string {
+	if e.Text == "" {
+		return fmt.Sprintf("%s %d", e.Kind, e.Number)
+	}
+	return fmt.Sprintf("%s %d: %s", e.Kind, e.Number, e.Text)
+}
+
+// lofHTML gives a list of figures as a list of links, figures first and
+// then tables.
+func lofHTML(entries []figureEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<ul class="lof">` + "\n")
+	for _, e := range entries {
+		fmt.Fprintf(buf, "<li class=\"lof-%s\"><a href=\"#%s\">%s</a></li>\n",
+			strings.ToLower(e.Kind), e.ID, html.EscapeString(e.caption()))
+	}
+	buf.WriteString("</ul>\n")
+	return buf.String()
+}
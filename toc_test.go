@@ -0,0 +1,84 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildTOCIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		ids  []string
+	}{
+		{"distinct", "<h1>One</h1><h2>Two</h2>", []string{"one", "two"}},
+		{"repeated", "<h2>A</h2><h2>A</h2><h2>A</h2>", []string{"a", "a-1", "a-2"}},
+		{"suffix taken", "<h2>A</h2><h2>A 1</h2><h2>A</h2>", []string{"a", "a-1", "a-2"}},
+		{"suffix taken first", "<h2>A 1</h2><h2>A</h2><h2>A</h2>", []string{"a-1", "a", "a-2"}},
+		{"existing id kept", `<h2 id="a">X</h2><h2>A</h2>`, []string{"a", "a-1"}},
+		{"deeper ignored", "<h4>Four</h4><h3>Three</h3>", []string{"three"}},
+	}
+	for _, tt := range tests {
+		_, entries := buildTOC([]byte(tt.html), slugger{})
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: got ids %v, want %v", tt.name, ids, tt.ids)
+		}
+	}
+}
+
+func TestBuildTOCEscapesIDs(t *testing.T) {
+	out, entries := buildTOC([]byte(`<h2>Say &quot;hi&quot; &lt;b&gt;</h2><h2 id="x&amp;y">X</h2>`), slugger{keepPunct: true})
+	for _, want := range []string{`<h2 id="say-&#34;hi&#34;-&lt;b&gt;">`, `<h2 id="x&amp;y">`} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	}
+	ids := []string{entries[0].ID, entries[1].ID}
+	if want := []string{`say-"hi"-<b>`, "x&y"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got ids %q, want %q", ids, want)
+	}
+	if toc := tocHTML(entries); !strings.Contains(toc, `href="#say-&#34;hi&#34;-&lt;b&gt;"`) {
+		t.Errorf("got %q", toc)
+	}
+}
+
+func TestInsertTOC(t *testing.T) {
+	const toc = "<ul>TOC</ul>\n"
+	tests := []struct {
+		name, html, want string
+	}{
+		{"paragraph", "<p>[[TOC]]</p>\n<h1>A</h1>", "<ul>TOC</ul>\n<h1>A</h1>"},
+		{"inline", "<li>[[TOC]]</li>", "<li><ul>TOC</ul>\n</li>"},
+		{"only the first", "<p>[[TOC]]</p>\n<p>[[TOC]]</p>\n", "<ul>TOC</ul>\n<p>[[TOC]]</p>\n"},
+		{"in a code block", "<pre><code>[[TOC]]\n</code></pre>\n<p>[[TOC]]</p>\n", "<pre><code>[[TOC]]\n</code></pre>\n<ul>TOC</ul>\n"},
+		{"in inline code", "<p>Write <code>[[TOC]]</code></p>\n", "<p>Write <code>[[TOC]]</code></p>\n"},
+		{"none", "<p>text</p>", "<p>text</p>"},
+	}
+	for _, tt := range tests {
+		if got := string(insertTOC([]byte(tt.html), tocMarker, toc)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
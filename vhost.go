@@ -0,0 +1,75 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Handling of requests for hosts that aren't virtual hosts.
+const (
+	vhostUnknownDefault  = "default"
+	vhostUnknownNotFound = "notfound"
+)
+
+// vhostSettings configures a virtual host, served from its own directory
+// with its own template and secrets. Every other setting is the same as
+// for the default site.
+type vhostSettings struct {
+	Host     string                 // required
+	Dir      string                 // required
+	Template string                 // optional, defaults to the default template
+	Secrets  map[string]routeSecret // optional
+}
+
+// vhostServers creates the servers of the virtual hosts by host name.
+// They share the cache, nonce key, TLS, and metrics of the default server,
+// which answers anything that isn't content before handing requests to
+// them. The settings were validated along with the default site's.
+func (st settings) vhostServers(s *server) map[string]*server {
+	vhosts := make(map[string]*server)
+	for _, v := range st.Vhosts {
+		host := strings.ToLower(v.Host)
+		vst := st
+		vst.Host, vst.Dir, vst.Template, vst.Secrets = v.Host, v.Dir, v.Template, v.Secrets
+		vst.Vhosts = nil
+		vst.Git.Repo = ""
+		vs := vst.siteServer()
+		vs.vhost = host
+		vs.nonceKey = s.nonceKey
+		vs.tls = s.tls
+		vs.metrics = s.metrics
+		vhosts[host] = vs
+	}
+	return vhosts
+}
+
+// vhostFor gives the server for the host of a request. Unknown hosts get
+// the default server, or none if they should get a 404.
+func (s *server) vhostFor(ctx *fasthttp.RequestCtx) (*server, bool) {
+	host := strings.ToLower(string(ctx.Host()))
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	if vs, ok := s.vhosts[host]; ok {
+		return vs, true
+	}
+	return s, s.vhostUnknown != vhostUnknownNotFound
+}
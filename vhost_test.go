@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	fp "path/filepath"
+	"strings"
+	"testing"
+)
+
+const vhostSettingsYAML = `ttl: 5
+vhosts:
+  - host: a.example.com
+    dir: a
+  - host: b.example.com
+    dir: b
+`
+
+func TestVhostCacheKeys(t *testing.T) {
+	s := newTestServer(t, vhostSettingsYAML, map[string]string{
+		"a/page.md": "site A",
+		"b/page.md": "site B",
+	})
+	// each is served twice, so the second comes from the cache
+	for i := 0; i < 2; i++ {
+		for _, tt := range []struct{ host, want string }{
+			{"a.example.com", "site A"},
+			{"b.example.com", "site B"},
+		} {
+			body := string(get(s, "http://"+tt.host+"/page").Body())
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("GET %s/page: got %q, want it to contain %q", tt.host, body, tt.want)
+			}
+		}
+	}
+	for _, key := range []string{"a.example.com/page", "b.example.com/page"} {
+		if _, ok := s.cache.Get(key); !ok {
+			t.Errorf("%s isn't cached", key)
+		}
+	}
+}
+
+func TestVhostFreshness(t *testing.T) {
+	yml := `ttl: 1
+secrets:
+  open: pw
+vhosts:
+  - host: a.example.com
+    dir: a
+    secrets:
+      private: pw
+`
+	s := newTestServer(t, yml, map[string]string{
+		"private/page.md":   "default",
+		"open/page.md":      "default",
+		"a/private/page.md": "site A",
+		"a/open/page.md":    "site A",
+	})
+	tests := []struct {
+		host, path, want string
+	}{
+		{"a.example.com", "/private/page", "private, max-age=60"},
+		{"a.example.com", "/open/page", "max-age=60"},
+		{"example.com", "/private/page", "max-age=60"},
+		{"example.com", "/open/page", "private, max-age=60"},
+	}
+	for _, tt := range tests {
+		resp := authGet(s, tt.path, "any", "pw", "Host", tt.host)
+		if got := string(resp.Header.Peek("Cache-Control")); resp.StatusCode() != 200 || got != tt.want {
+			t.Errorf("GET %s%s: got %d with Cache-Control %q, want %q", tt.host, tt.path, resp.StatusCode(), got, tt.want)
+		}
+	}
+}
+
+func TestVhosts(t *testing.T) {
+	files := map[string]string{
+		"page.md":    "default site",
+		"a/page.md":  "site A",
+		"a/tpl.html": "A {{ .Content }}",
+		"b/page.md":  "site B",
+		"b/only.md":  "only B",
+	}
+	yml := `vhosts:
+  - host: a.example.com
+    dir: a
+    template: a/tpl.html
+  - host: B.example.com
+    dir: b
+`
+	tests := []struct {
+		unknown string
+		host    string
+		path    string
+		status  int
+		want    string
+	}{
+		{"", "a.example.com", "/page", 200, "A <p>site A</p>"},
+		{"", "b.example.com", "/page", 200, "<p>site B</p>"},
+		{"", "B.EXAMPLE.COM:8080", "/page", 200, "<p>site B</p>"},
+		{"", "a.example.com", "/only", 404, ""},
+		{"", "b.example.com", "/only", 200, "<p>only B</p>"},
+		{"", "other.example.com", "/page", 200, "<p>default site</p>"},
+		{"default", "other.example.com", "/page", 200, "<p>default site</p>"},
+		{"notfound", "other.example.com", "/page", 404, ""},
+		{"notfound", "a.example.com", "/page", 200, "A <p>site A</p>"},
+	}
+	for _, tt := range tests {
+		settings := yml
+		if tt.unknown != "" {
+			settings += "vhost_unknown: " + tt.unknown + "\n"
+		}
+		s := newTestServer(t, settings, files)
+		resp := get(s, tt.path, "Host", tt.host)
+		if body := string(resp.Body()); resp.StatusCode() != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("vhost_unknown %q: GET %s%s: got %d %q, want %d %q", tt.unknown, tt.host, tt.path, resp.StatusCode(), body, tt.status, tt.want)
+		}
+	}
+}
+
+func TestVhostServersShare(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cert.pem": "", "key.pem": "",
+		"404.md": "default missing", "a/404.md": "A missing", "500.md": "broken",
+	})
+	set := fp.Join(dir, ".settings.yaml")
+	yml := tlsSettings + "notfound: 404.md\nerrors:\n  500: 500.md\nvhosts:\n  - host: a.example.com\n    dir: a\n"
+	if err := ioutil.WriteFile(set, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := readSettings(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := st.toServer()
+	if len(st.Errors) != 1 {
+		t.Errorf("settings' errors changed to %v", st.Errors)
+	}
+	vs := s.vhosts["a.example.com"]
+	if vs.tls != s.tls || &vs.nonceKey[0] != &s.nonceKey[0] {
+		t.Error("the virtual host has TLS or a nonce key of its own")
+	}
+	for _, tt := range []struct {
+		host, want string
+	}{
+		{"example.com", "default missing"},
+		{"a.example.com", "A missing"},
+	} {
+		resp := get(s, "https://"+tt.host+"/missing")
+		if resp.StatusCode() != 404 || !strings.Contains(string(resp.Body()), tt.want) {
+			t.Errorf("%s: got %d %q, want %q", tt.host, resp.StatusCode(), resp.Body(), tt.want)
+		}
+	}
+}
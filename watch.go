@@ -0,0 +1,160 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+	"path"
+	fp "path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchContent evicts the cached responses for files in the served
+// directory and mounts as they change, so edits show up without waiting
+// for the ttl. Every name an event touches is evicted, which covers
+// editors that save by renaming a temporary file over the original. A
+// change to a directory template flushes the whole cache.
+func (s *server) watchContent() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, root := range s.roots() {
+		if err := watchTree(w, root); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				s.contentChanged(w, ev)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("couldn't watch content: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// watchTree watches a directory and those below it, leaving out hidden
+// ones like .git.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return fp.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			return fp.SkipDir
+		}
+		return w.Add(p)
+	})
+}
+
+// contentChanged evicts the cached responses that a change to a file may
+// have made stale, and starts watching new directories.
+func (s *server) contentChanged(w *fsnotify.Watcher, ev fsnotify.Event) {
+	if ev.Op == fsnotify.Chmod {
+		return
+	}
+	if ev.Op&fsnotify.Create != 0 {
+		if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			if err := watchTree(w, ev.Name); err != nil {
+				log.Printf("couldn't watch %s: %v", ev.Name, err)
+			}
+		}
+	}
+	for _, name := range dirTemplateNames {
+		if fp.Base(ev.Name) == name {
+			s.flushCache("template " + ev.Name + " changed")
+			return
+		}
+	}
+	s.feedChanged(ev.Name)
+	pathStr := s.urlOf(ev.Name)
+	if pathStr == "" {
+		return
+	}
+	// a removed or renamed directory takes what's below it along
+	below := ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0
+	if n := s.evictPath(pathStr, below); n > 0 {
+		log.Printf("%s changed, evicted %d cached items", ev.Name, n)
+	}
+}
+
+// urlOf gives the request path of a file, under the mount it's in or the
+// served directory, or the empty string if it's in neither.
+func (s *server) urlOf(file string) string {
+	root := s.rootOf(fp.Dir(file))
+	if root == "" {
+		return ""
+	}
+	rel, err := fp.Rel(root, file)
+	if err != nil {
+		return ""
+	}
+	prefix := ""
+	for _, m := range s.mounts {
+		if m.root == root {
+			prefix = m.prefix
+			break
+		}
+	}
+	return path.Join("/", prefix, fp.ToSlash(rel))
+}
+
+// evictPath evicts the cached responses that a file at a request path
+// could have answered: the path itself, the path without its extension
+// (or language), and its directory for indexes and listings. Below also
+// evicts everything under the path. It gives the number evicted.
+func (s *server) evictPath(pathStr string, below bool) int {
+	dir, base := path.Split(pathStr)
+	candidates := []string{
+		pathStr,
+		dir + strings.TrimSuffix(base, path.Ext(base)),
+		dir + strings.SplitN(base, ".", 2)[0],
+		dir,
+		strings.TrimSuffix(dir, "/"),
+	}
+	evicted := 0
+	for key := range s.cache.Items() {
+		if !strings.HasPrefix(key, s.vhost) {
+			continue
+		}
+		p := strings.SplitN(strings.TrimPrefix(key, s.vhost), "?", 2)[0]
+		stale := below && strings.HasPrefix(p, pathStr+"/")
+		for _, c := range candidates {
+			stale = stale || p == c
+		}
+		if stale {
+			s.cache.Delete(key)
+			evicted++
+		}
+	}
+	return evicted
+}
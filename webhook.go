@@ -0,0 +1,71 @@
+/*
+Copyright (C) 2016-2023  Lore Anaya Pozo
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serveReload answers requests for the reload path, reporting whether the
+// request was one. A request signed with the reload secret flushes the
+// cache and, if configured, reloads the settings the way SIGHUP does.
+func (s *server) serveReload(ctx *fasthttp.RequestCtx) bool {
+	if s.reload.secret == "" || string(ctx.Path()) != s.reload.path {
+		return false
+	}
+	if !ctx.IsPost() {
+		ctx.Response.Header.Set("Allow", "POST")
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		logRequest(ctx, fasthttp.StatusMethodNotAllowed, "reload")
+		return true
+	}
+	if !validSignature(s.reload.secret, ctx.PostBody(), ctx.Request.Header.Peek("X-Hub-Signature-256")) {
+		ctx.Error("Unauthorized", fasthttp.StatusUnauthorized)
+		logRequest(ctx, fasthttp.StatusUnauthorized, "reload")
+		return true
+	}
+	if s.reload.settings && s.settingsFile != "" {
+		if err := s.reloadSecrets(); err != nil {
+			handlerInternalError(err, s.debug)(ctx)
+			return true
+		}
+	}
+	s.flushCache("reload request")
+	ctx.Response.Header.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString("reloaded\n")
+	logRequest(ctx, fasthttp.StatusOK, "reload")
+	return true
+}
+
+// validSignature reports whether a signature header, as sent by GitHub
+// and Gitea in the form "sha256=<hex>", is the HMAC-SHA256 of the body
+// with the secret.
+func validSignature(secret string, body, header []byte) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(string(header), "sha256="))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}